@@ -0,0 +1,294 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultAdminKeysLimit = 100
+
+// KeyInfo a key with its remaining time to live, TTL is negative if the key has expired
+type KeyInfo struct {
+	Key string
+	TTL time.Duration
+}
+
+// The following interfaces are optional for caches served by AdminHandler,
+// the endpoints needing them reply 501 Not Implemented for other caches.
+type (
+	// KeySampler samples keys of a cache
+	KeySampler interface {
+		SampleKeys(n int) []KeyInfo
+	}
+
+	// TTLReporter reports the remaining time to live of a key
+	TTLReporter interface {
+		TTL(key string) (time.Duration, bool)
+	}
+
+	// ConfigReporter reports the configuration of a cache
+	ConfigReporter interface {
+		Config() map[string]interface{}
+	}
+
+	// PrefixDeleter deletes keys by prefix
+	PrefixDeleter interface {
+		DeleteByPrefix(prefix string) int
+	}
+
+	// Purger deletes all keys
+	Purger interface {
+		Purge() int
+	}
+
+	// HotKeyReporter reports the most frequently accessed keys
+	HotKeyReporter interface {
+		HotKeys(k int) []HotKey
+	}
+)
+
+// AdminHandler returns an http.Handler serving JSON for live inspection and repair of caches:
+//
+//	GET  /                        names, types and sizes of all caches
+//	GET  /{name}                  size, configuration and stats of a cache
+//	GET  /{name}/keys?limit=N     a sample of keys with remaining ttl
+//	GET  /{name}/key?key=K        a single key
+//	GET  /{name}/hot?limit=N      the most frequently accessed keys
+//	POST /{name}/delete?key=K     delete a key
+//	POST /{name}/purge?prefix=P   delete keys by prefix
+//	POST /{name}/purge            delete all keys
+//
+// Stats are those returned by a Stats() method of the cache, if any.
+// Mount it with http.StripPrefix when serving under a sub path.
+func AdminHandler(caches map[string]Cache) http.Handler {
+	return &adminHandler{caches: caches}
+}
+
+type adminHandler struct {
+	caches map[string]Cache
+}
+
+type adminCacheInfo struct {
+	Name   string                 `json:"name"`
+	Type   string                 `json:"type"`
+	Size   int                    `json:"size"`
+	Config map[string]interface{} `json:"config,omitempty"`
+	Stats  interface{}            `json:"stats,omitempty"`
+}
+
+type adminKey struct {
+	Key   string      `json:"key"`
+	Found bool        `json:"found"`
+	Value interface{} `json:"value,omitempty"`
+	Error string      `json:"error,omitempty"`
+	// TTLMillis remaining time to live in milliseconds, negative if expired
+	TTLMillis *int64 `json:"ttl_ms,omitempty"`
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	if path == "" {
+		h.list(w, r)
+		return
+	}
+
+	parts := strings.SplitN(path, "/", 2)
+	c, ok := h.caches[parts[0]]
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, "cache not found")
+		return
+	}
+
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	method := http.MethodGet
+	switch action {
+	case "delete", "purge":
+		method = http.MethodPost
+	}
+	if r.Method != method {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	switch action {
+	case "":
+		writeAdminJSON(w, cacheInfo(parts[0], c, true))
+	case "keys":
+		h.keys(w, r, c)
+	case "key":
+		h.key(w, r, c)
+	case "hot":
+		h.hot(w, r, c)
+	case "delete":
+		key := r.URL.Query().Get("key")
+		c.Delete(key)
+		writeAdminJSON(w, map[string]string{"deleted": key})
+	case "purge":
+		h.purge(w, r, c)
+	default:
+		writeAdminError(w, http.StatusNotFound, "unknown endpoint")
+	}
+}
+
+func (h *adminHandler) list(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	names := make([]string, 0, len(h.caches))
+	for name := range h.caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	infos := make([]adminCacheInfo, 0, len(names))
+	for _, name := range names {
+		infos = append(infos, cacheInfo(name, h.caches[name], false))
+	}
+	writeAdminJSON(w, infos)
+}
+
+func cacheInfo(name string, c Cache, detail bool) adminCacheInfo {
+	info := adminCacheInfo{
+		Name: name,
+		Type: reflect.TypeOf(c).String(),
+		Size: c.Size(),
+	}
+	if !detail {
+		return info
+	}
+
+	if cr, ok := c.(ConfigReporter); ok {
+		info.Config = cr.Config()
+	}
+	// any Stats() method with one result
+	if m := reflect.ValueOf(c).MethodByName("Stats"); m.IsValid() && m.Type().NumIn() == 0 && m.Type().NumOut() == 1 {
+		info.Stats = m.Call(nil)[0].Interface()
+	}
+	return info
+}
+
+func (h *adminHandler) keys(w http.ResponseWriter, r *http.Request, c Cache) {
+	sampler, ok := c.(KeySampler)
+	if !ok {
+		writeAdminError(w, http.StatusNotImplemented, "cache does not support key sampling")
+		return
+	}
+
+	limit, ok := adminLimit(w, r)
+	if !ok {
+		return
+	}
+
+	keys := sampler.SampleKeys(limit)
+	rsp := make([]adminKey, 0, len(keys))
+	for _, k := range keys {
+		ttl := k.TTL.Milliseconds()
+		rsp = append(rsp, adminKey{Key: k.Key, Found: true, TTLMillis: &ttl})
+	}
+	writeAdminJSON(w, rsp)
+}
+
+func (h *adminHandler) hot(w http.ResponseWriter, r *http.Request, c Cache) {
+	reporter, ok := c.(HotKeyReporter)
+	if !ok {
+		writeAdminError(w, http.StatusNotImplemented, "cache does not support hot keys")
+		return
+	}
+
+	limit, ok := adminLimit(w, r)
+	if !ok {
+		return
+	}
+
+	keys := reporter.HotKeys(limit)
+	if keys == nil {
+		keys = []HotKey{}
+	}
+	writeAdminJSON(w, keys)
+}
+
+// adminLimit parses the limit query, replying 400 Bad Request if it is bad
+func adminLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	limit := defaultAdminKeysLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeAdminError(w, http.StatusBadRequest, "bad limit")
+			return 0, false
+		}
+		limit = n
+	}
+	return limit, true
+}
+
+func (h *adminHandler) key(w http.ResponseWriter, r *http.Request, c Cache) {
+	key := r.URL.Query().Get("key")
+	rsp := adminKey{Key: key}
+
+	v, ok := c.Get(key)
+	if ok && v != nil {
+		rsp.Found = true
+		rsp.Value = jsonSafe(v.Val)
+		if v.Err != nil {
+			rsp.Error = v.Err.Error()
+		}
+		if tr, ok := c.(TTLReporter); ok {
+			if ttl, ok := tr.TTL(key); ok {
+				ms := ttl.Milliseconds()
+				rsp.TTLMillis = &ms
+			}
+		}
+	}
+	writeAdminJSON(w, rsp)
+}
+
+func (h *adminHandler) purge(w http.ResponseWriter, r *http.Request, c Cache) {
+	prefix, byPrefix := r.URL.Query()["prefix"]
+	if byPrefix && prefix[0] != "" {
+		pd, ok := c.(PrefixDeleter)
+		if !ok {
+			writeAdminError(w, http.StatusNotImplemented, "cache does not support purge by prefix")
+			return
+		}
+		writeAdminJSON(w, map[string]int{"deleted": pd.DeleteByPrefix(prefix[0])})
+		return
+	}
+
+	p, ok := c.(Purger)
+	if !ok {
+		writeAdminError(w, http.StatusNotImplemented, "cache does not support purge")
+		return
+	}
+	writeAdminJSON(w, map[string]int{"deleted": p.Purge()})
+}
+
+// jsonSafe returns v if it can be encoded to JSON, otherwise its string form
+func jsonSafe(v interface{}) interface{} {
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return v
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
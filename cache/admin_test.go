@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func doAdmin(t *testing.T, h http.Handler, method, target string, rsp interface{}) int {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	if rsp != nil {
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), rsp))
+	}
+	return w.Code
+}
+
+func TestAdminHandler(t *testing.T) {
+	users := NewLRUCache(MaxSize(100))
+	users.Put("tenant:1:user:1", "alice", ExpirationOption(time.Hour))
+	users.Put("tenant:1:user:2", "bob", ExpirationOption(time.Hour))
+	users.Put("tenant:2:user:1", "carol", ExpirationOption(time.Hour))
+	tiered := NewTieredCache(NewLRUCache())
+	h := AdminHandler(map[string]Cache{"users": users, "tiered": tiered})
+
+	var list []adminCacheInfo
+	assert.Equal(t, http.StatusOK, doAdmin(t, h, http.MethodGet, "/", &list))
+	assert.Len(t, list, 2)
+	assert.Equal(t, "tiered", list[0].Name)
+	assert.Equal(t, "users", list[1].Name)
+	assert.Equal(t, 3, list[1].Size)
+
+	var info adminCacheInfo
+	assert.Equal(t, http.StatusOK, doAdmin(t, h, http.MethodGet, "/users", &info))
+	assert.Equal(t, "*cache.LRUCache", info.Type)
+	assert.Equal(t, float64(100), info.Config["max_size"])
+	assert.Equal(t, http.StatusOK, doAdmin(t, h, http.MethodGet, "/tiered", &info))
+	assert.NotNil(t, info.Stats)
+
+	var keys []adminKey
+	assert.Equal(t, http.StatusOK, doAdmin(t, h, http.MethodGet, "/users/keys?limit=2", &keys))
+	assert.Len(t, keys, 2)
+	assert.Equal(t, "tenant:2:user:1", keys[0].Key)
+	assert.True(t, *keys[0].TTLMillis > 0)
+
+	var key adminKey
+	assert.Equal(t, http.StatusOK, doAdmin(t, h, http.MethodGet, "/users/key?key=tenant:1:user:2", &key))
+	assert.True(t, key.Found)
+	assert.Equal(t, "bob", key.Value)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, doAdmin(t, h, http.MethodGet, "/users/delete?key=tenant:1:user:2", nil))
+	assert.Equal(t, http.StatusOK, doAdmin(t, h, http.MethodPost, "/users/delete?key=tenant:1:user:2", nil))
+	assert.Equal(t, 2, users.Size())
+
+	var deleted map[string]int
+	assert.Equal(t, http.StatusOK, doAdmin(t, h, http.MethodPost, "/users/purge?prefix=tenant:2:", &deleted))
+	assert.Equal(t, 1, deleted["deleted"])
+	assert.Equal(t, http.StatusOK, doAdmin(t, h, http.MethodPost, "/users/purge", &deleted))
+	assert.Equal(t, 1, deleted["deleted"])
+	assert.Equal(t, 0, users.Size())
+
+	assert.Equal(t, http.StatusNotImplemented, doAdmin(t, h, http.MethodGet, "/tiered/keys", nil))
+	assert.Equal(t, http.StatusNotFound, doAdmin(t, h, http.MethodGet, "/missing", nil))
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// circuit breaker states
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+var circuitStateNames = [...]string{"closed", "open", "half-open"}
+
+// circuitBreaker stops calling the loader of a cache after failureThreshold consecutive failures.
+// While open, loads fail fast with ErrCircuitOpen. After openTimeout one load is let through
+// as a probe, its success closes the circuit and its failure opens it again.
+type circuitBreaker struct {
+	mu    sync.Mutex
+	clock Clock
+
+	failureThreshold int
+	openTimeout      time.Duration
+
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(failureThreshold int, openTimeout time.Duration, clock Clock) *circuitBreaker {
+	if failureThreshold <= 0 {
+		failureThreshold = 1
+	}
+	return &circuitBreaker{
+		clock:            clock,
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+	}
+}
+
+// allow reports whether the loader can be called, probe is true if the call is the probe
+// of a half-open circuit. The result of an allowed call must be reported by done.
+func (b *circuitBreaker) allow() (allowed, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if b.clock.Now().Sub(b.openedAt) < b.openTimeout {
+			return false, false
+		}
+		b.state = circuitHalfOpen
+		b.probing = true
+		return true, true
+	case circuitHalfOpen:
+		if b.probing {
+			return false, false
+		}
+		b.probing = true
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+// done reports the result of an allowed call
+func (b *circuitBreaker) done(probe, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitClosed:
+		if ok {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.failureThreshold {
+			b.openLocked()
+		}
+	case circuitHalfOpen:
+		// results of calls allowed before the circuit opened are ignored
+		if !probe {
+			return
+		}
+		b.probing = false
+		if ok {
+			b.state = circuitClosed
+			b.failures = 0
+			return
+		}
+		b.openLocked()
+	}
+}
+
+func (b *circuitBreaker) openLocked() {
+	b.state = circuitOpen
+	b.openedAt = b.clock.Now()
+	b.probing = false
+}
+
+// stateName returns closed, open or half-open
+func (b *circuitBreaker) stateName() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return circuitStateNames[b.state]
+}
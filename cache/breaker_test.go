@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errOrigin = errors.New("origin down")
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 30 * time.Millisecond}
+	assert.Equal(t, 10*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 20*time.Millisecond, p.backoff(2))
+	assert.Equal(t, 30*time.Millisecond, p.backoff(3))
+	assert.Equal(t, 30*time.Millisecond, p.backoff(100))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.backoff(2)
+		assert.True(t, d > 10*time.Millisecond && d <= 20*time.Millisecond, d)
+	}
+}
+
+func TestLRUCache_LoadRetry(t *testing.T) {
+	cache := NewLRUCache()
+
+	var calls int32
+	loader := WithLoader(func(key string) *Value {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return &Value{Err: errOrigin}
+		}
+		return &Value{Val: key}
+	})
+	retry := WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+
+	v, _ := cache.Load("k", loader, retry)
+	assert.Nil(t, v.Err)
+	assert.Equal(t, "k", v.Val)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// not retryable
+	atomic.StoreInt32(&calls, 0)
+	retry = WithRetryPolicy(RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		Retryable:   func(err error) bool { return !errors.Is(err, errOrigin) },
+	})
+	v, _ = cache.Load("k2", loader, retry)
+	assert.Equal(t, errOrigin, v.Err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestLRUCache_LoadRetryTimeout(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cache := NewLRUCache(WithClock(clock))
+
+	var calls int32
+	failed := make(chan struct{}, 1)
+	done := make(chan *Value)
+	go func() {
+		v, _ := cache.Load("k",
+			WithLoaderTimeout(time.Second),
+			WithRetryPolicy(RetryPolicy{MaxAttempts: 10, BaseDelay: 400 * time.Millisecond}),
+			WithLoader(func(key string) *Value {
+				atomic.AddInt32(&calls, 1)
+				failed <- struct{}{}
+				return &Value{Err: errOrigin}
+			}))
+		done <- v
+	}()
+
+	// attempts at 0 and 400ms, the timeout at 1s comes before the attempt at 1.2s,
+	// pending timers are the clean ticker, the load timeout and the retry backoff
+	<-failed
+	clock.BlockUntil(3)
+	clock.Advance(400 * time.Millisecond)
+	<-failed
+	clock.BlockUntil(3)
+	clock.Advance(600 * time.Millisecond)
+	v := <-done
+	assert.True(t, errors.Is(v.Err, ErrLoadTimeout))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestLRUCache_CircuitBreaker(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cache := NewLRUCache(WithClock(clock), WithCircuitBreaker(2, time.Minute))
+
+	var calls int32
+	var down atomic.Value
+	down.Store(true)
+	loader := WithLoader(func(key string) *Value {
+		atomic.AddInt32(&calls, 1)
+		if down.Load().(bool) {
+			return &Value{Err: errOrigin}
+		}
+		return &Value{Val: key}
+	})
+
+	for _, k := range []string{"a", "b"} {
+		v, _ := cache.Load(k, loader)
+		assert.Equal(t, errOrigin, v.Err)
+	}
+	assert.Equal(t, "open", cache.Config()["circuit"])
+
+	// fail fast
+	v, _ := cache.Load("c", loader)
+	assert.Equal(t, ErrCircuitOpen, v.Err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// the failed probe opens the circuit again
+	clock.Advance(time.Minute)
+	v, _ = cache.Load("d", loader)
+	assert.Equal(t, errOrigin, v.Err)
+	v, _ = cache.Load("e", loader)
+	assert.Equal(t, ErrCircuitOpen, v.Err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// the successful probe closes the circuit
+	down.Store(false)
+	clock.Advance(time.Minute)
+	v, _ = cache.Load("f", loader)
+	assert.Nil(t, v.Err)
+	assert.Equal(t, "closed", cache.Config()["circuit"])
+	v, _ = cache.Load("g", loader)
+	assert.Equal(t, "g", v.Val)
+}
+
+func TestLRUCache_StaleOnCircuitOpen(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cache := NewLRUCache(WithClock(clock), WithCircuitBreaker(1, time.Minute))
+
+	loader := WithLoader(func(key string) *Value {
+		return &Value{Err: errOrigin}
+	})
+
+	cache.Put("k", 1, ExpirationOption(time.Second))
+	cache.Load("other", loader)
+	clock.Advance(time.Second)
+
+	// sync load
+	v, ok := cache.Load("k", loader, SyncLoad(true), StaleOnCircuitOpen(true))
+	assert.True(t, ok)
+	assert.Equal(t, 1, v.Val)
+
+	// async refresh keeps the stale value
+	v, _ = cache.Load("k", loader, StaleOnCircuitOpen(true))
+	assert.Equal(t, 1, v.Val)
+	eventually(t, func() bool {
+		cache.lock.RLock()
+		defer cache.lock.RUnlock()
+		entry := cache.values["k"].Value.(*internalEntry)
+		return entry.innerValue.Val == 1 && !entry.refreshed.Load()
+	})
+
+	// without stale, the error replaces the value
+	v, ok = cache.Load("k", loader, SyncLoad(true))
+	assert.False(t, ok)
+	assert.Equal(t, ErrCircuitOpen, v.Err)
+}
@@ -0,0 +1,294 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	defaultBytesMaxBytes = 32 << 20
+	defaultBytesShards   = 256
+
+	// entry header: key hash(8) | expiration(8) | key length(4) | value length(4)
+	bytesEntryHeaderSize = 24
+)
+
+var (
+	// ErrEntryTooLarge the entry can not fit into a shard of the cache
+	ErrEntryTooLarge = errors.New("entry too large")
+)
+
+// BytesOptions options for bytes cache
+type BytesOptions struct {
+	maxBytes int
+	shards   int
+}
+
+// BytesOption ...
+type BytesOption func(options *BytesOptions)
+
+// NewBytesOptions new a bytes options
+func NewBytesOptions() BytesOptions {
+	return BytesOptions{
+		maxBytes: defaultBytesMaxBytes,
+		shards:   defaultBytesShards,
+	}
+}
+
+// BytesMaxBytes set the total size of slabs, it is preallocated
+func BytesMaxBytes(v int) BytesOption {
+	return func(options *BytesOptions) {
+		options.maxBytes = v
+	}
+}
+
+// BytesShards set the number of shards, each shard has its own lock and slab
+func BytesShards(v int) BytesOption {
+	return func(options *BytesOptions) {
+		options.shards = v
+	}
+}
+
+// BytesCache is a cache of []byte values which stores entries in large preallocated
+// byte slabs, indexed by maps from key hash to slab offset. Neither the slabs nor
+// the indexes contain pointers, so the GC does not scan the entries however many
+// they are.
+//
+// Each shard writes entries to its slab as a ring, when the slab is full the oldest
+// entries are overwritten (FIFO eviction). Keys are stored with values and verified
+// on read, so hash collisions never return the value of another key.
+type BytesCache struct {
+	shards []*bytesShard
+}
+
+// NewBytesCache new a bytes cache
+func NewBytesCache(opt ...BytesOption) *BytesCache {
+	opts := NewBytesOptions()
+	for _, o := range opt {
+		o(&opts)
+	}
+
+	if opts.shards <= 0 {
+		opts.shards = 1
+	}
+	shardSize := opts.maxBytes / opts.shards
+	if int64(shardSize) > math.MaxUint32 {
+		shardSize = math.MaxUint32
+	}
+
+	cache := &BytesCache{shards: make([]*bytesShard, opts.shards)}
+	for i := range cache.shards {
+		cache.shards[i] = newBytesShard(shardSize)
+	}
+
+	return cache
+}
+
+func (cache *BytesCache) shard(keyHash uint64) *bytesShard {
+	return cache.shards[keyHash%uint64(len(cache.shards))]
+}
+
+// Get returns a copy of the value of key
+func (cache *BytesCache) Get(key string) ([]byte, bool) {
+	h := xxhash.Sum64String(key)
+	return cache.shard(h).get(nil, key, h)
+}
+
+// GetAppend appends the value of key to dst and returns the result,
+// it avoids allocation when dst has enough capacity.
+func (cache *BytesCache) GetAppend(dst []byte, key string) ([]byte, bool) {
+	h := xxhash.Sum64String(key)
+	return cache.shard(h).get(dst, key, h)
+}
+
+// Put stores a copy of value. Only ExpirationOption of opts is used,
+// entries without expiration live until they are evicted.
+func (cache *BytesCache) Put(key string, value []byte, opts ...EntryOption) error {
+	eOpts := NewEntryOptions()
+	for _, o := range opts {
+		o(&eOpts)
+	}
+
+	var expiration int64
+	if eOpts.expireAfterWrite > 0 {
+		expiration = time.Now().Add(eOpts.expireAfterWrite).UnixNano()
+	}
+
+	h := xxhash.Sum64String(key)
+	return cache.shard(h).put(key, h, value, expiration)
+}
+
+// Delete deletes the entry of key, its space is reclaimed when the slab wraps around
+func (cache *BytesCache) Delete(key string) {
+	h := xxhash.Sum64String(key)
+	cache.shard(h).delete(key, h)
+}
+
+// Size returns the number of entries, including expired entries not yet read or evicted
+func (cache *BytesCache) Size() int {
+	n := 0
+	for _, s := range cache.shards {
+		s.lock.RLock()
+		n += len(s.m)
+		s.lock.RUnlock()
+	}
+	return n
+}
+
+// bytesShard a slab written as a ring
+//
+// When not wrapped, entries are in [head, tail).
+// When wrapped, entries are in [head, wrapAt) and [0, tail).
+type bytesShard struct {
+	lock sync.RWMutex
+
+	m   map[uint64]uint32
+	buf []byte
+
+	head    uint32
+	tail    uint32
+	wrapAt  uint32
+	wrapped bool
+}
+
+func newBytesShard(size int) *bytesShard {
+	return &bytesShard{
+		m:   make(map[uint64]uint32),
+		buf: make([]byte, size),
+	}
+}
+
+type bytesEntryHeader struct {
+	keyHash    uint64
+	expiration int64
+	keyLen     uint32
+	valLen     uint32
+}
+
+func (h *bytesEntryHeader) size() uint32 {
+	return bytesEntryHeaderSize + h.keyLen + h.valLen
+}
+
+func (s *bytesShard) readHeader(offset uint32) bytesEntryHeader {
+	b := s.buf[offset : offset+bytesEntryHeaderSize]
+	return bytesEntryHeader{
+		keyHash:    binary.LittleEndian.Uint64(b[0:8]),
+		expiration: int64(binary.LittleEndian.Uint64(b[8:16])),
+		keyLen:     binary.LittleEndian.Uint32(b[16:20]),
+		valLen:     binary.LittleEndian.Uint32(b[20:24]),
+	}
+}
+
+// lookupLocked returns the offset and header of key, false if key is not in the slab
+func (s *bytesShard) lookupLocked(key string, keyHash uint64) (uint32, bytesEntryHeader, bool) {
+	offset, ok := s.m[keyHash]
+	if !ok {
+		return 0, bytesEntryHeader{}, false
+	}
+
+	h := s.readHeader(offset)
+	keyStart := offset + bytesEntryHeaderSize
+	// hash collision, the slot belongs to another key
+	if h.keyHash != keyHash || h.keyLen != uint32(len(key)) || string(s.buf[keyStart:keyStart+h.keyLen]) != key {
+		return 0, bytesEntryHeader{}, false
+	}
+	return offset, h, true
+}
+
+func (s *bytesShard) get(dst []byte, key string, keyHash uint64) ([]byte, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	offset, h, ok := s.lookupLocked(key, keyHash)
+	if !ok || (h.expiration != 0 && h.expiration <= time.Now().UnixNano()) {
+		return dst, false
+	}
+
+	valStart := offset + bytesEntryHeaderSize + h.keyLen
+	return append(dst, s.buf[valStart:valStart+h.valLen]...), true
+}
+
+func (s *bytesShard) put(key string, keyHash uint64, value []byte, expiration int64) error {
+	h := bytesEntryHeader{
+		keyHash:    keyHash,
+		expiration: expiration,
+		keyLen:     uint32(len(key)),
+		valLen:     uint32(len(value)),
+	}
+	n := uint64(bytesEntryHeaderSize) + uint64(len(key)) + uint64(len(value))
+	if n > uint64(len(s.buf)) {
+		return ErrEntryTooLarge
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	offset := s.allocLocked(uint32(n))
+	b := s.buf[offset:]
+	binary.LittleEndian.PutUint64(b[0:8], h.keyHash)
+	binary.LittleEndian.PutUint64(b[8:16], uint64(h.expiration))
+	binary.LittleEndian.PutUint32(b[16:20], h.keyLen)
+	binary.LittleEndian.PutUint32(b[20:24], h.valLen)
+	copy(b[bytesEntryHeaderSize:], key)
+	copy(b[bytesEntryHeaderSize+h.keyLen:], value)
+
+	// a colliding key is replaced
+	s.m[keyHash] = offset
+	return nil
+}
+
+// allocLocked reserves n bytes at the tail of the ring, evicting the oldest entries
+func (s *bytesShard) allocLocked(n uint32) uint32 {
+	for {
+		if !s.wrapped {
+			if uint64(s.tail)+uint64(n) <= uint64(len(s.buf)) {
+				break
+			}
+			s.wrapAt = s.tail
+			s.tail = 0
+			s.wrapped = true
+			continue
+		}
+
+		if uint64(s.tail)+uint64(n) <= uint64(s.head) {
+			break
+		}
+		s.evictHeadLocked()
+	}
+
+	offset := s.tail
+	s.tail += n
+	return offset
+}
+
+// evictHeadLocked removes the oldest entry of a wrapped ring
+func (s *bytesShard) evictHeadLocked() {
+	if s.head < s.wrapAt {
+		h := s.readHeader(s.head)
+		// the key may have been overwritten or deleted
+		if offset, ok := s.m[h.keyHash]; ok && offset == s.head {
+			delete(s.m, h.keyHash)
+		}
+		s.head += h.size()
+	}
+
+	if s.head >= s.wrapAt {
+		s.head = 0
+		s.wrapAt = 0
+		s.wrapped = false
+	}
+}
+
+func (s *bytesShard) delete(key string, keyHash uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, _, ok := s.lookupLocked(key, keyHash); ok {
+		delete(s.m, keyHash)
+	}
+}
@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBytesCache_PutGet(t *testing.T) {
+	cache := NewBytesCache(BytesMaxBytes(1<<20), BytesShards(4))
+
+	assert.NoError(t, cache.Put("a", []byte("1")))
+	v, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1"), v)
+
+	assert.NoError(t, cache.Put("a", []byte("22")))
+	v, ok = cache.GetAppend([]byte("x"), "a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("x22"), v)
+	assert.Equal(t, 1, cache.Size())
+
+	cache.Delete("a")
+	_, ok = cache.Get("a")
+	assert.False(t, ok)
+
+	assert.NoError(t, cache.Put("b", []byte("1"), ExpirationOption(time.Millisecond)))
+	time.Sleep(2 * time.Millisecond)
+	_, ok = cache.Get("b")
+	assert.False(t, ok)
+
+	assert.Equal(t, ErrEntryTooLarge, cache.Put("c", make([]byte, 1<<20)))
+}
+
+func TestBytesCache_Collision(t *testing.T) {
+	cache := NewBytesCache(BytesMaxBytes(1024), BytesShards(1))
+	s := cache.shards[0]
+
+	// force two keys onto the same hash
+	assert.NoError(t, s.put("a", 1, []byte("va"), 0))
+	_, ok := s.get(nil, "b", 1)
+	assert.False(t, ok)
+
+	assert.NoError(t, s.put("b", 1, []byte("vb"), 0))
+	_, ok = s.get(nil, "a", 1)
+	assert.False(t, ok)
+	v, ok := s.get(nil, "b", 1)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("vb"), v)
+
+	s.delete("a", 1)
+	_, ok = s.get(nil, "b", 1)
+	assert.True(t, ok)
+}
+
+func TestBytesCache_Evict(t *testing.T) {
+	cache := NewBytesCache(BytesMaxBytes(4096), BytesShards(1))
+
+	value := bytes.Repeat([]byte{'v'}, 100)
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, cache.Put(strconv.Itoa(i), value))
+	}
+
+	// the latest entries survive, oldest are evicted
+	assert.True(t, cache.Size() < 1000)
+	for i := 1000 - cache.Size(); i < 1000; i++ {
+		v, ok := cache.Get(strconv.Itoa(i))
+		assert.True(t, ok)
+		assert.Equal(t, value, v)
+	}
+	_, ok := cache.Get("0")
+	assert.False(t, ok)
+}
+
+func TestBytesCache_Concurrent(t *testing.T) {
+	cache := NewBytesCache(BytesMaxBytes(1<<16), BytesShards(8))
+
+	wg := sync.WaitGroup{}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 10000; i++ {
+				k := strconv.Itoa(i % 500)
+				if i%3 == 0 {
+					_ = cache.Put(k, []byte(k))
+					continue
+				}
+				if v, ok := cache.Get(k); ok && string(v) != k {
+					t.Errorf("key %s got value %s", k, v)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,333 @@
+// Package cachetest provides a conformance suite checking that implementations of cache.Cache
+// behave like cache.LRUCache.
+package cachetest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"goober/cache"
+)
+
+const (
+	concurrentWorkers = 8
+	concurrentOps     = 500
+	concurrentKeys    = 16
+
+	// long enough for entries not to expire within a test
+	noExpiration = time.Hour
+)
+
+var errLoader = errors.New("loader failed")
+
+// RunConformance runs the conformance suite against caches created by newCache, except the tests
+// of expiration and loader timeout which need to control time, see RunConformanceWithClock.
+// newCache must return an empty cache each time it is called.
+func RunConformance(t *testing.T, newCache func() cache.Cache) {
+	run(t, func(clock cache.Clock) cache.Cache {
+		return newCache()
+	}, false)
+}
+
+// RunConformanceWithClock runs the full conformance suite against caches created by newCache,
+// which must use clock for expiration and loader timeout. The clock is a *cache.FakeClock
+// moved forward by the suite.
+func RunConformanceWithClock(t *testing.T, newCache func(clock cache.Clock) cache.Cache) {
+	run(t, newCache, true)
+}
+
+type conformanceTest struct {
+	name      string
+	needClock bool
+	f         func(t *testing.T, c cache.Cache, clock *cache.FakeClock)
+}
+
+var conformanceTests = []conformanceTest{
+	{name: "GetMissing", f: testGetMissing},
+	{name: "PutGet", f: testPutGet},
+	{name: "Delete", f: testDelete},
+	{name: "Size", f: testSize},
+	{name: "CompareAndSwap", f: testCompareAndSwap},
+	{name: "Load", f: testLoad},
+	{name: "LoadError", f: testLoadError},
+	{name: "LoadWithoutLoader", f: testLoadWithoutLoader},
+	{name: "Concurrent", f: testConcurrent},
+	{name: "Expiration", needClock: true, f: testExpiration},
+	{name: "LoaderTimeout", needClock: true, f: testLoaderTimeout},
+}
+
+func run(t *testing.T, newCache func(clock cache.Clock) cache.Cache, withClock bool) {
+	for _, test := range conformanceTests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if test.needClock && !withClock {
+				t.Skip("needs RunConformanceWithClock")
+			}
+			clock := cache.NewFakeClock(time.Unix(1600000000, 0))
+			test.f(t, newCache(clock), clock)
+		})
+	}
+}
+
+func testGetMissing(t *testing.T, c cache.Cache, clock *cache.FakeClock) {
+	v, ok := c.Get("missing")
+	assert.False(t, ok)
+	assert.Nil(t, v)
+}
+
+func testPutGet(t *testing.T, c cache.Cache, clock *cache.FakeClock) {
+	c.Put("k", "v1", cache.ExpirationOption(noExpiration))
+	v, ok := c.Get("k")
+	assert.True(t, ok)
+	assert.Equal(t, "v1", v.Val)
+	assert.Nil(t, v.Err)
+
+	c.Put("k", "v2", cache.ExpirationOption(noExpiration))
+	v, ok = c.Get("k")
+	assert.True(t, ok)
+	assert.Equal(t, "v2", v.Val)
+}
+
+func testDelete(t *testing.T, c cache.Cache, clock *cache.FakeClock) {
+	c.Put("a", "1", cache.ExpirationOption(noExpiration))
+	c.Put("b", "2", cache.ExpirationOption(noExpiration))
+
+	c.Delete("a")
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+	v, ok := c.Get("b")
+	assert.True(t, ok)
+	assert.Equal(t, "2", v.Val)
+	assert.Equal(t, 1, c.Size())
+
+	// deleting a missing key does nothing
+	c.Delete("a")
+	c.Delete("missing")
+	assert.Equal(t, 1, c.Size())
+}
+
+func testSize(t *testing.T, c cache.Cache, clock *cache.FakeClock) {
+	assert.Equal(t, 0, c.Size())
+	for i := 0; i < 3; i++ {
+		c.Put(fmt.Sprint(i), i, cache.ExpirationOption(noExpiration))
+	}
+	assert.Equal(t, 3, c.Size())
+
+	// replacing does not grow
+	c.Put("0", "0", cache.ExpirationOption(noExpiration))
+	assert.Equal(t, 3, c.Size())
+}
+
+func testCompareAndSwap(t *testing.T, c cache.Cache, clock *cache.FakeClock) {
+	exp := cache.ExpirationOption(noExpiration)
+
+	// nil old matches a missing key
+	cur, swapped := c.CompareAndSwap("k", nil, "a", exp)
+	assert.True(t, swapped)
+	assert.Equal(t, "a", cur)
+	v, ok := c.Get("k")
+	assert.True(t, ok)
+	assert.Equal(t, "a", v.Val)
+
+	// the existing element is returned if not swapped
+	cur, swapped = c.CompareAndSwap("k", nil, "b", exp)
+	assert.False(t, swapped)
+	if assert.IsType(t, &cache.Value{}, cur) {
+		assert.Equal(t, "a", cur.(*cache.Value).Val)
+	}
+	v, _ = c.Get("k")
+	assert.Equal(t, "a", v.Val)
+
+	// the element got from the cache matches
+	cur, swapped = c.CompareAndSwap("k", v, "b", exp)
+	assert.True(t, swapped)
+	assert.Equal(t, "b", cur)
+	stale := v
+	v, _ = c.Get("k")
+	assert.Equal(t, "b", v.Val)
+
+	cur, swapped = c.CompareAndSwap("k", stale, "c", exp)
+	assert.False(t, swapped)
+	if assert.IsType(t, &cache.Value{}, cur) {
+		assert.Equal(t, "b", cur.(*cache.Value).Val)
+	}
+
+	// nil is returned if the key is missing
+	c.Delete("k")
+	cur, swapped = c.CompareAndSwap("k", v, "c", exp)
+	assert.False(t, swapped)
+	assert.Nil(t, cur)
+	_, ok = c.Get("k")
+	assert.False(t, ok)
+}
+
+func testLoad(t *testing.T, c cache.Cache, clock *cache.FakeClock) {
+	var calls int32
+	loader := cache.WithLoader(func(key string) *cache.Value {
+		atomic.AddInt32(&calls, 1)
+		return &cache.Value{Val: "loaded_" + key}
+	})
+
+	v, loaded := c.Load("k", loader, cache.ExpirationOption(noExpiration))
+	assert.False(t, loaded)
+	assert.Equal(t, "loaded_k", v.Val)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	v, loaded = c.Load("k", loader, cache.ExpirationOption(noExpiration))
+	assert.True(t, loaded)
+	assert.Equal(t, "loaded_k", v.Val)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	v, ok := c.Get("k")
+	assert.True(t, ok)
+	assert.Equal(t, "loaded_k", v.Val)
+
+	// put values are loaded without calling the loader
+	c.Put("p", "put", cache.ExpirationOption(noExpiration))
+	v, loaded = c.Load("p", loader, cache.ExpirationOption(noExpiration))
+	assert.True(t, loaded)
+	assert.Equal(t, "put", v.Val)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func testLoadError(t *testing.T, c cache.Cache, clock *cache.FakeClock) {
+	v, loaded := c.Load("k", cache.WithLoader(func(key string) *cache.Value {
+		return &cache.Value{Err: errLoader}
+	}), cache.ExpirationOption(noExpiration))
+	assert.False(t, loaded)
+	assert.True(t, errors.Is(v.Err, errLoader))
+}
+
+func testLoadWithoutLoader(t *testing.T, c cache.Cache, clock *cache.FakeClock) {
+	v, loaded := c.Load("missing")
+	assert.False(t, loaded)
+	assert.Nil(t, v)
+
+	c.Put("k", "v", cache.ExpirationOption(noExpiration))
+	v, loaded = c.Load("k")
+	assert.True(t, loaded)
+	assert.Equal(t, "v", v.Val)
+}
+
+// testConcurrent checks every value got belongs to its key and the size is bounded, run it with -race
+func testConcurrent(t *testing.T, c cache.Cache, clock *cache.FakeClock) {
+	exp := cache.ExpirationOption(noExpiration)
+	loader := cache.WithLoader(func(key string) *cache.Value {
+		return &cache.Value{Val: key + ":loaded"}
+	})
+	check := func(key string, v *cache.Value) {
+		if v == nil || v.Err != nil {
+			return
+		}
+		s, ok := v.Val.(string)
+		if !ok || !strings.HasPrefix(s, key+":") {
+			t.Errorf("value %v of key %s", v.Val, key)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrentWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < concurrentOps; i++ {
+				key := fmt.Sprint((w*7 + i) % concurrentKeys)
+				val := fmt.Sprintf("%s:%d:%d", key, w, i)
+				switch i % 5 {
+				case 0:
+					c.Put(key, val, exp)
+				case 1:
+					v, _ := c.Get(key)
+					check(key, v)
+				case 2:
+					v, _ := c.Load(key, loader, exp)
+					check(key, v)
+				case 3:
+					var old interface{}
+					if v, ok := c.Get(key); ok {
+						check(key, v)
+						old = v
+					}
+					c.CompareAndSwap(key, old, val, exp)
+				case 4:
+					if i%10 == 4 {
+						c.Delete(key)
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	assert.True(t, c.Size() <= concurrentKeys)
+	for i := 0; i < concurrentKeys; i++ {
+		key := fmt.Sprint(i)
+		v, _ := c.Get(key)
+		check(key, v)
+	}
+}
+
+func testExpiration(t *testing.T, c cache.Cache, clock *cache.FakeClock) {
+	var calls int32
+	loader := cache.WithLoader(func(key string) *cache.Value {
+		return &cache.Value{Val: fmt.Sprintf("loaded_%d", atomic.AddInt32(&calls, 1))}
+	})
+
+	c.Put("k", "put", cache.ExpirationOption(time.Minute))
+	clock.Advance(time.Minute - time.Second)
+	v, loaded := c.Load("k", loader, cache.SyncLoad(true), cache.ExpirationOption(time.Minute))
+	assert.True(t, loaded)
+	assert.Equal(t, "put", v.Val)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+
+	// expired, sync load calls the loader
+	clock.Advance(time.Second)
+	v, loaded = c.Load("k", loader, cache.SyncLoad(true), cache.ExpirationOption(time.Minute))
+	assert.False(t, loaded)
+	assert.Equal(t, "loaded_1", v.Val)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// the loaded value expires as well
+	clock.Advance(time.Minute)
+	v, _ = c.Load("k", loader, cache.SyncLoad(true), cache.ExpirationOption(time.Minute))
+	assert.Equal(t, "loaded_2", v.Val)
+}
+
+func testLoaderTimeout(t *testing.T, c cache.Cache, clock *cache.FakeClock) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+
+	type result struct {
+		v      *cache.Value
+		loaded bool
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, loaded := c.Load("k", cache.WithLoaderTimeout(time.Second), cache.WithLoader(func(key string) *cache.Value {
+			close(started)
+			<-release
+			return &cache.Value{Val: "late"}
+		}))
+		done <- result{v, loaded}
+	}()
+
+	<-started
+	select {
+	case <-done:
+		t.Fatal("load returned before timeout")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	r := <-done
+	assert.False(t, r.loaded)
+	assert.True(t, errors.Is(r.v.Err, cache.ErrLoadTimeout))
+}
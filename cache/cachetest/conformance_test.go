@@ -0,0 +1,56 @@
+package cachetest
+
+import (
+	"testing"
+
+	"goober/cache"
+)
+
+func TestLRUCache(t *testing.T) {
+	RunConformanceWithClock(t, func(clock cache.Clock) cache.Cache {
+		return cache.NewLRUCache(cache.WithClock(clock))
+	})
+}
+
+func TestTieredCache(t *testing.T) {
+	RunConformance(t, func() cache.Cache {
+		return cache.NewTieredCache(cache.NewLRUCache())
+	})
+
+	// LRUCache serves expired entries, so L2 is a DiskCache for expiration
+	RunConformanceWithClock(t, func(clock cache.Clock) cache.Cache {
+		return cache.NewTieredCache(newDiskCache(t, clock), cache.L1Options(cache.WithClock(clock)))
+	})
+}
+
+func TestDiskCache(t *testing.T) {
+	RunConformanceWithClock(t, func(clock cache.Clock) cache.Cache {
+		return newDiskCache(t, clock)
+	})
+}
+
+func TestDistributedCache(t *testing.T) {
+	RunConformanceWithClock(t, func(clock cache.Clock) cache.Cache {
+		return cache.NewDistributedCache("conformance", "http://127.0.0.1:0",
+			cache.LocalOptions(cache.WithClock(clock)))
+	})
+}
+
+func newDiskCache(t *testing.T, clock cache.Clock) *cache.DiskCache {
+	c, err := cache.OpenDiskCache(t.TempDir(), cache.DiskClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c
+}
+
+func TestExpiringMap(t *testing.T) {
+	RunConformanceWithClock(t, func(clock cache.Clock) cache.Cache {
+		m := cache.NewExpiringMap(cache.ExpiringClock(clock))
+		t.Cleanup(m.Close)
+		return m
+	})
+}
@@ -0,0 +1,255 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock provides time to caches, so expiration and refresh can be tested with FakeClock
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Ticker like time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Timer like time.Timer created by time.AfterFunc
+type Timer interface {
+	Stop() bool
+}
+
+// SystemClock the Clock of package time
+type SystemClock struct{}
+
+// Now ...
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTicker ...
+func (SystemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+// AfterFunc ...
+func (SystemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// FakeClock a Clock only moved by Advance.
+//
+// Advance fires due timers and tickers synchronously in time order: timer functions
+// are called by Advance, and each tick is sent to the ticker channel, waiting until it
+// is received. Ticks of the background goroutines of caches are also waited for until
+// they are handled, so their effects are visible when Advance returns. Advance must not
+// be called while holding a lock those goroutines need.
+type FakeClock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFakeClock new a fake clock starting at now
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// fakeTimer a timer or a ticker, period is 0 for timers
+type fakeTimer struct {
+	clock  *FakeClock
+	when   time.Time
+	period time.Duration
+	f      func()
+
+	c chan time.Time
+	// handled receives an acknowledgement for each tick handled, nil if ticks are not acknowledged
+	handled chan struct{}
+	// stopped is closed by Stop
+	stopped chan struct{}
+}
+
+// Now ...
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// NewTicker ...
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	return c.newTicker(d, false)
+}
+
+func (c *FakeClock) newTicker(d time.Duration, acknowledged bool) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	t := &fakeTimer{clock: c, period: d, c: make(chan time.Time), stopped: make(chan struct{})}
+	if acknowledged {
+		t.handled = make(chan struct{})
+	}
+	c.add(t, d)
+	return fakeTicker{t}
+}
+
+// AfterFunc ...
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &fakeTimer{clock: c, f: f, stopped: make(chan struct{})}
+	c.add(t, d)
+	return t
+}
+
+func (c *FakeClock) add(t *fakeTimer, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t.when = c.now.Add(d)
+	c.timers = append(c.timers, t)
+	c.cond.Broadcast()
+}
+
+// BlockUntil blocks until n timers and tickers are pending, so a test can Advance
+// after another goroutine has created its timer
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+// Advance moves the clock forward by d, firing timers and tickers due within it
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		t := c.nextLocked(end)
+		if t == nil {
+			break
+		}
+		c.now = t.when
+		now := c.now
+
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+		} else {
+			c.removeLocked(t)
+		}
+
+		// the receiver of the tick or f may use the clock
+		c.mu.Unlock()
+		if t.period > 0 {
+			t.tick(now)
+		} else {
+			t.f()
+		}
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+// nextLocked returns the earliest timer due before end
+func (c *FakeClock) nextLocked(end time.Time) *fakeTimer {
+	sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].when.Before(c.timers[j].when) })
+	if len(c.timers) == 0 || c.timers[0].when.After(end) {
+		return nil
+	}
+	return c.timers[0]
+}
+
+func (c *FakeClock) removeLocked(t *fakeTimer) bool {
+	for i, tt := range c.timers {
+		if tt == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			c.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+// tick sends now to the ticker channel and waits until it is handled, unless the ticker is stopped
+func (t *fakeTimer) tick(now time.Time) {
+	select {
+	case t.c <- now:
+	case <-t.stopped:
+		return
+	}
+	if t.handled == nil {
+		return
+	}
+	select {
+	case <-t.handled:
+	case <-t.stopped:
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	if !t.clock.removeLocked(t) {
+		return false
+	}
+	close(t.stopped)
+	return true
+}
+
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
+
+func (t fakeTicker) tickHandled() {
+	if t.handled == nil {
+		return
+	}
+	select {
+	case t.handled <- struct{}{}:
+	case <-t.stopped:
+	}
+}
+
+// newHandledTicker creates a ticker of clock for a background goroutine, which calls
+// tickHandled after handling each tick, so that FakeClock.Advance waits for it
+func newHandledTicker(clock Clock, d time.Duration) Ticker {
+	if c, ok := clock.(*FakeClock); ok {
+		return c.newTicker(d, true)
+	}
+	return clock.NewTicker(d)
+}
+
+// tickHandled acknowledges a tick of a ticker created by newHandledTicker
+func tickHandled(t Ticker) {
+	if ft, ok := t.(fakeTicker); ok {
+		ft.tickHandled()
+	}
+}
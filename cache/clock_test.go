@@ -0,0 +1,199 @@
+package cache
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeClock_Advance(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+
+	var fired []int
+	clock.AfterFunc(2*time.Second, func() { fired = append(fired, 2) })
+	clock.AfterFunc(time.Second, func() { fired = append(fired, 1) })
+	stopped := clock.AfterFunc(time.Second, func() { fired = append(fired, 0) })
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+
+	ticker := clock.NewTicker(time.Second)
+	ticks := make(chan time.Time, 10)
+	go func() {
+		for tick := range ticker.C() {
+			ticks <- tick
+		}
+	}()
+
+	clock.Advance(500 * time.Millisecond)
+	assert.Empty(t, fired)
+	assert.Equal(t, start.Add(500*time.Millisecond), clock.Now())
+
+	// ticks are received before Advance returns, none is dropped
+	clock.Advance(time.Second)
+	assert.Equal(t, []int{1}, fired)
+	assert.Equal(t, start.Add(time.Second), <-ticks)
+
+	clock.Advance(3 * time.Second)
+	assert.Equal(t, []int{1, 2}, fired)
+	for i := 2; i <= 4; i++ {
+		assert.Equal(t, start.Add(time.Duration(i)*time.Second), <-ticks)
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Second)
+	assert.Empty(t, ticks)
+}
+
+func TestFakeClock_HandledTicker(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	ticker := newHandledTicker(clock, time.Second)
+	defer ticker.Stop()
+
+	var handled int32
+	go func() {
+		for range ticker.C() {
+			// Advance waits for the tick to be handled
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&handled, 1)
+			tickHandled(ticker)
+		}
+	}()
+
+	clock.Advance(time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&handled))
+	clock.Advance(3 * time.Second)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&handled))
+}
+
+func TestFakeClock_BlockUntil(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	fired := make(chan struct{})
+	go clock.AfterFunc(time.Second, func() { close(fired) })
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	<-fired
+}
+
+func TestLRUCache_FakeClockExpiration(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cache := NewLRUCache(WithClock(clock))
+
+	cache.Put("k", 1, ExpirationOption(time.Minute))
+	ttl, ok := cache.TTL("k")
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, ttl)
+
+	clock.Advance(59 * time.Second)
+	v, ok := cache.Get("k")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v.Val)
+
+	clock.Advance(time.Second)
+	ttl, ok = cache.TTL("k")
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), ttl)
+
+	// cleaned by the ticker of the clean goroutine
+	clock.Advance(defaultCleanDuration)
+	_, ok = cache.Get("k")
+	assert.False(t, ok)
+}
+
+func TestLRUCache_FakeClockRefresh(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cache := NewLRUCache(WithClock(clock))
+
+	var calls int32
+	loader := WithLoader(func(key string) *Value {
+		return &Value{Val: atomic.AddInt32(&calls, 1)}
+	})
+
+	v, ok := cache.Load("k", loader, ExpirationOption(time.Minute))
+	assert.False(t, ok)
+	assert.Equal(t, int32(1), v.Val)
+
+	v, ok = cache.Load("k", loader, ExpirationOption(time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, int32(1), v.Val)
+
+	// expired, the stale value is returned and refreshed asynchronously
+	clock.Advance(time.Minute)
+	v, _ = cache.Load("k", loader, ExpirationOption(time.Minute))
+	assert.Equal(t, int32(1), v.Val)
+	eventually(t, func() bool {
+		v, ok := cache.Get("k")
+		return ok && v.Val == int32(2)
+	})
+
+	// expired with sync load, loaded before return
+	clock.Advance(time.Minute)
+	v, _ = cache.Load("k", loader, ExpirationOption(time.Minute), SyncLoad(true))
+	assert.Equal(t, int32(3), v.Val)
+}
+
+func TestLRUCache_FakeClockLoadTimeout(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cache := NewLRUCache(WithClock(clock))
+
+	release := make(chan struct{})
+	defer close(release)
+	loading := make(chan struct{})
+	done := make(chan *Value)
+	go func() {
+		v, _ := cache.Load("k", WithLoaderTimeout(time.Second), WithLoader(func(key string) *Value {
+			close(loading)
+			<-release
+			return &Value{Val: 1}
+		}))
+		done <- v
+	}()
+
+	// pending timers are the clean ticker and the load timeout
+	<-loading
+	clock.BlockUntil(2)
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("load returned before timeout")
+	default:
+	}
+
+	clock.Advance(time.Millisecond)
+	v := <-done
+	assert.True(t, errors.Is(v.Err, ErrLoadTimeout))
+}
+
+func TestMap_LoaderWithExpiredFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	m := &Map{}
+	m.SetClock(clock)
+
+	var calls int32
+	f := func() (interface{}, error) {
+		return atomic.AddInt32(&calls, 1), nil
+	}
+
+	v, err := m.LoaderWithExpired("k", f, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), v)
+
+	clock.Advance(30 * time.Second)
+	v, _ = m.LoaderWithExpired("k", f, time.Minute)
+	assert.Equal(t, int32(1), v)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// expired, the stale value is returned and refreshed asynchronously
+	clock.Advance(30 * time.Second)
+	v, _ = m.LoaderWithExpired("k", f, time.Minute)
+	assert.Equal(t, int32(1), v)
+	eventually(t, func() bool {
+		v, _ := m.LoaderWithExpired("k", f, time.Minute)
+		return v == int32(2)
+	})
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+)
+
+// Codec encodes values to bytes and decodes them back,
+// it is used by caches storing values outside of the Go heap.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte) (interface{}, error)
+}
+
+// GobCodec encodes values with encoding/gob.
+// Values of non-builtin types must be registered with gob.Register.
+type GobCodec struct{}
+
+// Marshal ...
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(&v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal ...
+func (GobCodec) Unmarshal(data []byte) (interface{}, error) {
+	var v interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// BytesCodec stores []byte and string values as is,
+// values are always decoded as []byte.
+type BytesCodec struct{}
+
+// Marshal ...
+func (BytesCodec) Marshal(v interface{}) ([]byte, error) {
+	switch b := v.(type) {
+	case []byte:
+		return b, nil
+	case string:
+		return []byte(b), nil
+	default:
+		return nil, fmt.Errorf("bytes codec: unsupported value type %T", v)
+	}
+}
+
+// Unmarshal ...
+func (BytesCodec) Unmarshal(data []byte) (interface{}, error) {
+	return data, nil
+}
@@ -0,0 +1,763 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDiskSegmentSize        = 64 << 20
+	defaultDiskMaxBytes           = 1 << 30
+	defaultDiskCompactInterval    = 10 * time.Minute
+	defaultDiskCompactGarbageRate = 0.5
+
+	// diskBudgetLowWater the rate of the byte budget the cache is shrunk to once over budget,
+	// so that compaction does not run again on each following write
+	diskBudgetLowWater = 0.9
+
+	segmentFileSuffix = ".seg"
+
+	// record header: crc32(4) | flag(1) | expiration(8) | key length(4) | value length(4)
+	recordHeaderSize = 21
+
+	recordFlagPut    byte = 1
+	recordFlagDelete byte = 2
+)
+
+var (
+	// ErrCorruptRecord a segment record failed checksum verification
+	ErrCorruptRecord = errors.New("corrupt disk cache record")
+	// ErrCacheClosed the cache has been closed
+	ErrCacheClosed = errors.New("cache closed")
+)
+
+// DiskOptions options for disk cache
+type DiskOptions struct {
+	segmentSize int64
+	maxBytes    int64
+
+	compactInterval    time.Duration
+	compactGarbageRate float64
+
+	codec Codec
+
+	// clock provides time for expiration and compaction
+	clock Clock
+
+	// default options for each kv entry
+	defaultEntryOpts EntryOptions
+}
+
+// DiskOption ...
+type DiskOption func(options *DiskOptions)
+
+// NewDiskOptions new a disk options
+func NewDiskOptions() DiskOptions {
+	return DiskOptions{
+		segmentSize:        defaultDiskSegmentSize,
+		maxBytes:           defaultDiskMaxBytes,
+		compactInterval:    defaultDiskCompactInterval,
+		compactGarbageRate: defaultDiskCompactGarbageRate,
+		codec:              GobCodec{},
+		clock:              SystemClock{},
+		defaultEntryOpts:   NewEntryOptions(),
+	}
+}
+
+// DiskSegmentSize set the size at which the active segment file is sealed and a new one is created
+func DiskSegmentSize(v int64) DiskOption {
+	return func(options *DiskOptions) {
+		options.segmentSize = v
+	}
+}
+
+// DiskMaxBytes set the byte budget of all segment files. Once over budget, the cache is
+// compacted and the oldest segments are dropped until it is below 90% of the budget.
+func DiskMaxBytes(v int64) DiskOption {
+	return func(options *DiskOptions) {
+		options.maxBytes = v
+	}
+}
+
+// DiskCompactInterval set the interval of background compaction
+func DiskCompactInterval(t time.Duration) DiskOption {
+	return func(options *DiskOptions) {
+		options.compactInterval = t
+	}
+}
+
+// DiskCompactGarbageRate set the rate of dead bytes above which a sealed segment is compacted
+func DiskCompactGarbageRate(v float64) DiskOption {
+	return func(options *DiskOptions) {
+		options.compactGarbageRate = v
+	}
+}
+
+// DiskCodec set the codec of values
+func DiskCodec(c Codec) DiskOption {
+	return func(options *DiskOptions) {
+		options.codec = c
+	}
+}
+
+// DiskClock set the clock of the cache
+func DiskClock(c Clock) DiskOption {
+	return func(options *DiskOptions) {
+		options.clock = c
+	}
+}
+
+// DiskDefaultEntryOpts set default options for each kv entry
+func DiskDefaultEntryOpts(opts ...EntryOption) DiskOption {
+	return func(options *DiskOptions) {
+		eOpts := options.defaultEntryOpts
+		for _, eOpt := range opts {
+			eOpt(&eOpts)
+		}
+		options.defaultEntryOpts = eOpts
+	}
+}
+
+// segment an append-only file of records
+type segment struct {
+	id   uint64
+	f    *os.File
+	size int64
+	// live bytes of records still referenced by the index
+	live int64
+}
+
+func (s *segment) garbageRate() float64 {
+	if s.size == 0 {
+		return 0
+	}
+	return 1 - float64(s.live)/float64(s.size)
+}
+
+// diskLocation location of the latest record of a key
+type diskLocation struct {
+	seg        uint64
+	offset     int64
+	size       int64
+	expiration int64
+}
+
+type diskRecord struct {
+	flag       byte
+	expiration int64
+	key        string
+	value      []byte
+}
+
+func (r *diskRecord) size() int64 {
+	return int64(recordHeaderSize + len(r.key) + len(r.value))
+}
+
+func (r *diskRecord) encode() []byte {
+	buf := make([]byte, r.size())
+	buf[4] = r.flag
+	binary.LittleEndian.PutUint64(buf[5:13], uint64(r.expiration))
+	binary.LittleEndian.PutUint32(buf[13:17], uint32(len(r.key)))
+	binary.LittleEndian.PutUint32(buf[17:21], uint32(len(r.value)))
+	copy(buf[recordHeaderSize:], r.key)
+	copy(buf[recordHeaderSize+len(r.key):], r.value)
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+// decodeRecordHeader returns the record without key and value, and the length of them
+func decodeRecordHeader(header []byte) (r diskRecord, keyLen, valLen int) {
+	r.flag = header[4]
+	r.expiration = int64(binary.LittleEndian.Uint64(header[5:13]))
+	keyLen = int(binary.LittleEndian.Uint32(header[13:17]))
+	valLen = int(binary.LittleEndian.Uint32(header[17:21]))
+	return
+}
+
+// decodeRecord decodes a whole record, verifying its checksum
+func decodeRecord(buf []byte) (diskRecord, error) {
+	if len(buf) < recordHeaderSize {
+		return diskRecord{}, ErrCorruptRecord
+	}
+	r, keyLen, valLen := decodeRecordHeader(buf)
+	if len(buf) != recordHeaderSize+keyLen+valLen ||
+		binary.LittleEndian.Uint32(buf[0:4]) != crc32.ChecksumIEEE(buf[4:]) {
+		return diskRecord{}, ErrCorruptRecord
+	}
+	r.key = string(buf[recordHeaderSize : recordHeaderSize+keyLen])
+	r.value = buf[recordHeaderSize+keyLen:]
+	return r, nil
+}
+
+// DiskCache is a Cache storing values in append-only segment files under a directory,
+// with an in-memory index of keys.
+//
+// Values are encoded by the configured Codec. Overwritten, deleted and expired records
+// are reclaimed by compaction, and the index is rebuilt by replaying segments when the
+// cache is opened again. It is suitable as the L2 of a TieredCache.
+//
+// Entries without expiration never expire.
+type DiskCache struct {
+	lock sync.RWMutex
+
+	dir      string
+	index    map[string]diskLocation
+	segments map[uint64]*segment
+	active   *segment
+	// total bytes of all segment files
+	totalBytes int64
+
+	closed   bool
+	stopChan chan struct{}
+
+	opts DiskOptions
+}
+
+// OpenDiskCache opens the disk cache stored in dir, creating dir if not exists.
+// Existing segments are replayed to rebuild the index, a torn record at the end of a
+// segment left by a crash is truncated.
+func OpenDiskCache(dir string, opt ...DiskOption) (*DiskCache, error) {
+	opts := NewDiskOptions()
+	for _, o := range opt {
+		o(&opts)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	cache := &DiskCache{
+		dir:      dir,
+		index:    make(map[string]diskLocation),
+		segments: make(map[uint64]*segment),
+		stopChan: make(chan struct{}),
+		opts:     opts,
+	}
+
+	if err := cache.replay(); err != nil {
+		cache.closeFiles()
+		return nil, err
+	}
+
+	go cache.asyncCompact(newHandledTicker(opts.clock, opts.compactInterval))
+
+	return cache, nil
+}
+
+func (cache *DiskCache) segmentPath(id uint64) string {
+	return filepath.Join(cache.dir, fmt.Sprintf("%016x%s", id, segmentFileSuffix))
+}
+
+func (cache *DiskCache) replay() error {
+	files, err := ioutil.ReadDir(cache.dir)
+	if err != nil {
+		return err
+	}
+
+	var ids []uint64
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, segmentFileSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentFileSuffix), 16, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		if err := cache.replaySegment(id); err != nil {
+			return err
+		}
+	}
+
+	var nextID uint64
+	if len(ids) > 0 {
+		nextID = ids[len(ids)-1] + 1
+	}
+	return cache.newActiveSegment(nextID)
+}
+
+func (cache *DiskCache) replaySegment(id uint64) error {
+	f, err := os.OpenFile(cache.segmentPath(id), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	seg := &segment{id: id, f: f}
+	cache.segments[id] = seg
+	now := cache.opts.clock.Now().UnixNano()
+
+	var offset int64
+	for offset < int64(len(data)) {
+		r, n, err := nextRecord(data[offset:])
+		if err != nil {
+			// a torn write at the tail, drop it
+			if err := f.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+
+		cache.unlinkLocked(r.key)
+		if r.flag == recordFlagPut && (r.expiration == 0 || r.expiration > now) {
+			cache.index[r.key] = diskLocation{seg: id, offset: offset, size: n, expiration: r.expiration}
+			seg.live += n
+		}
+		offset += n
+	}
+
+	seg.size = offset
+	cache.totalBytes += offset
+	return nil
+}
+
+// nextRecord decodes the first record of data, returning it and its size
+func nextRecord(data []byte) (diskRecord, int64, error) {
+	if len(data) < recordHeaderSize {
+		return diskRecord{}, 0, ErrCorruptRecord
+	}
+	_, keyLen, valLen := decodeRecordHeader(data)
+	n := recordHeaderSize + keyLen + valLen
+	if n > len(data) {
+		return diskRecord{}, 0, ErrCorruptRecord
+	}
+	r, err := decodeRecord(data[:n])
+	return r, int64(n), err
+}
+
+func (cache *DiskCache) newActiveSegment(id uint64) error {
+	f, err := os.OpenFile(cache.segmentPath(id), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	seg := &segment{id: id, f: f}
+	cache.segments[id] = seg
+	cache.active = seg
+	return nil
+}
+
+// unlinkLocked removes key from the index, accounting its record as garbage
+func (cache *DiskCache) unlinkLocked(key string) (diskLocation, bool) {
+	loc, ok := cache.index[key]
+	if !ok {
+		return loc, false
+	}
+	delete(cache.index, key)
+	if seg, ok := cache.segments[loc.seg]; ok {
+		seg.live -= loc.size
+	}
+	return loc, true
+}
+
+// appendLocked appends a record to the active segment, rotating it if it is full
+func (cache *DiskCache) appendLocked(r *diskRecord) (diskLocation, error) {
+	if cache.active.size > 0 && cache.active.size+r.size() > cache.opts.segmentSize {
+		if err := cache.active.f.Sync(); err != nil {
+			return diskLocation{}, err
+		}
+		if err := cache.newActiveSegment(cache.active.id + 1); err != nil {
+			return diskLocation{}, err
+		}
+	}
+
+	seg := cache.active
+	buf := r.encode()
+	if _, err := seg.f.WriteAt(buf, seg.size); err != nil {
+		return diskLocation{}, err
+	}
+
+	loc := diskLocation{seg: seg.id, offset: seg.size, size: int64(len(buf)), expiration: r.expiration}
+	seg.size += loc.size
+	cache.totalBytes += loc.size
+	return loc, nil
+}
+
+// readLocked reads and decodes the value of the record at loc
+func (cache *DiskCache) readLocked(loc diskLocation) (interface{}, error) {
+	seg, ok := cache.segments[loc.seg]
+	if !ok {
+		return nil, ErrCorruptRecord
+	}
+
+	buf := make([]byte, loc.size)
+	if _, err := seg.f.ReadAt(buf, loc.offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	r, err := decodeRecord(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return cache.opts.codec.Unmarshal(r.value)
+}
+
+func (cache *DiskCache) getLocked(key string) (*Value, bool) {
+	if cache.closed {
+		return nil, false
+	}
+
+	loc, ok := cache.index[key]
+	if !ok || (loc.expiration != 0 && loc.expiration <= cache.opts.clock.Now().UnixNano()) {
+		return nil, false
+	}
+
+	val, err := cache.readLocked(loc)
+	return &Value{Val: val, Err: err}, true
+}
+
+func (cache *DiskCache) putLocked(key string, value interface{}, eOpts EntryOptions) error {
+	if cache.closed {
+		return ErrCacheClosed
+	}
+
+	data, err := cache.opts.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	r := &diskRecord{flag: recordFlagPut, key: key, value: data}
+	if eOpts.expireAfterWrite > 0 {
+		r.expiration = cache.opts.clock.Now().Add(eOpts.expireAfterWrite).UnixNano()
+	}
+
+	loc, err := cache.appendLocked(r)
+	if err != nil {
+		return err
+	}
+
+	cache.unlinkLocked(key)
+	cache.index[key] = loc
+	cache.active.live += loc.size
+
+	return cache.enforceBudgetLocked()
+}
+
+// enforceBudgetLocked compacts segments when the cache exceeds its byte budget,
+// and drops the oldest segments until the cache is below the low water mark.
+func (cache *DiskCache) enforceBudgetLocked() error {
+	if cache.totalBytes <= cache.opts.maxBytes {
+		return nil
+	}
+
+	if err := cache.compactLocked(0); err != nil {
+		return err
+	}
+
+	lowWater := int64(float64(cache.opts.maxBytes) * diskBudgetLowWater)
+	for cache.totalBytes > lowWater {
+		oldest := cache.oldestSegmentLocked()
+		if oldest == cache.active {
+			return nil
+		}
+		for key, loc := range cache.index {
+			if loc.seg == oldest.id {
+				cache.unlinkLocked(key)
+			}
+		}
+		if err := cache.removeSegmentLocked(oldest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cache *DiskCache) oldestSegmentLocked() *segment {
+	oldest := cache.active
+	for _, seg := range cache.segments {
+		if seg.id < oldest.id {
+			oldest = seg
+		}
+	}
+	return oldest
+}
+
+func (cache *DiskCache) removeSegmentLocked(seg *segment) error {
+	delete(cache.segments, seg.id)
+	cache.totalBytes -= seg.size
+	_ = seg.f.Close()
+	return os.Remove(cache.segmentPath(seg.id))
+}
+
+// compactLocked rewrites sealed segments whose garbage rate is above garbageRate,
+// copying their live records to the active segment. Expired records are dropped, with a
+// tombstone while an older segment may hold a record of the key.
+func (cache *DiskCache) compactLocked(garbageRate float64) error {
+	var ids []uint64
+	for id, seg := range cache.segments {
+		if seg != cache.active {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	now := cache.opts.clock.Now().UnixNano()
+	// expired entries are garbage as well
+	for key, loc := range cache.index {
+		if loc.expiration != 0 && loc.expiration <= now {
+			cache.unlinkLocked(key)
+		}
+	}
+
+	for i, id := range ids {
+		seg := cache.segments[id]
+		if seg.garbageRate() <= garbageRate && seg.live != 0 {
+			continue
+		}
+		// tombstones are only needed while an older segment may hold the deleted key
+		if err := cache.compactSegmentLocked(seg, i > 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cache *DiskCache) compactSegmentLocked(seg *segment, keepTombstones bool) error {
+	data := make([]byte, seg.size)
+	if _, err := seg.f.ReadAt(data, 0); err != nil && err != io.EOF {
+		return err
+	}
+
+	now := cache.opts.clock.Now().UnixNano()
+	// keys of dropped expired records given a tombstone
+	expired := make(map[string]bool)
+
+	var offset int64
+	for offset < seg.size {
+		r, n, err := nextRecord(data[offset:])
+		if err != nil {
+			return err
+		}
+
+		loc, ok := cache.index[r.key]
+		switch {
+		case r.flag == recordFlagPut && ok && loc.seg == seg.id && loc.offset == offset:
+			newLoc, err := cache.appendLocked(&r)
+			if err != nil {
+				return err
+			}
+			cache.index[r.key] = newLoc
+			cache.active.live += newLoc.size
+			seg.live -= n
+		case r.flag == recordFlagDelete && !ok && keepTombstones:
+			if _, err := cache.appendLocked(&r); err != nil {
+				return err
+			}
+		case r.flag == recordFlagPut && !ok && keepTombstones && r.expiration != 0 && r.expiration <= now && !expired[r.key]:
+			// the expired record may hide an older record of the key on replay
+			expired[r.key] = true
+			if _, err := cache.appendLocked(&diskRecord{flag: recordFlagDelete, key: r.key}); err != nil {
+				return err
+			}
+		}
+		offset += n
+	}
+
+	return cache.removeSegmentLocked(seg)
+}
+
+func (cache *DiskCache) asyncCompact(t Ticker) {
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C():
+			_ = cache.Compact()
+			tickHandled(t)
+		case <-cache.stopChan:
+			return
+		}
+	}
+}
+
+// Compact rewrites segments with more dead bytes than the configured garbage rate,
+// dropping overwritten, deleted and expired records.
+func (cache *DiskCache) Compact() error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.closed {
+		return ErrCacheClosed
+	}
+	return cache.compactLocked(cache.opts.compactGarbageRate)
+}
+
+// Get retrieves an element based on a key,
+// a failure reading the element is returned as the Err of value.
+func (cache *DiskCache) Get(key string) (*Value, bool) {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	return cache.getLocked(key)
+}
+
+// Put adds an element to the cache, returning the previous element.
+// A failure writing the element is returned as the Err of the returned Value.
+func (cache *DiskCache) Put(key string, value interface{}, opts ...EntryOption) interface{} {
+	eOpts := cache.opts.defaultEntryOpts
+	for _, o := range opts {
+		o(&eOpts)
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	pre, ok := cache.getLocked(key)
+	if err := cache.putLocked(key, value, eOpts); err != nil {
+		return &Value{Err: err}
+	}
+	if ok {
+		return pre
+	}
+	return &Value{Val: value}
+}
+
+// Delete deletes an element in the cache
+func (cache *DiskCache) Delete(key string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.closed {
+		return
+	}
+	if _, ok := cache.unlinkLocked(key); !ok {
+		return
+	}
+	_, _ = cache.appendLocked(&diskRecord{flag: recordFlagDelete, key: key})
+}
+
+// Size returns the number of entries currently stored in the Cache,
+// including expired entries not yet compacted.
+func (cache *DiskCache) Size() int {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	return len(cache.index)
+}
+
+// Bytes returns the total size of all segment files
+func (cache *DiskCache) Bytes() int64 {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	return cache.totalBytes
+}
+
+// CompareAndSwap adds an element to the cache if the existing value deeply equals the value of
+// old, a nil old matches a missing element. Elements are decoded on each read, so an old *Value
+// returned by Get matches by its Val instead of identity.
+// It returns the existing element, nil if missing, and false if not swapped.
+func (cache *DiskCache) CompareAndSwap(key string, old, new interface{}, opts ...EntryOption) (interface{}, bool) {
+	eOpts := cache.opts.defaultEntryOpts
+	for _, o := range opts {
+		o(&eOpts)
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	v, ok := cache.getLocked(key)
+	if !casValueMatch(v, ok, old) {
+		return casPre(v, ok), false
+	}
+	if err := cache.putLocked(key, new, eOpts); err != nil {
+		return casPre(v, ok), false
+	}
+
+	return new, true
+}
+
+// casValueMatch reports whether the element v matches old of CompareAndSwap by value,
+// old is either a *Value or a raw value
+func casValueMatch(v *Value, ok bool, old interface{}) bool {
+	if !ok {
+		return old == nil
+	}
+	if o, isValue := old.(*Value); isValue && o != nil {
+		old = o.Val
+	}
+	return v.Err == nil && reflect.DeepEqual(v.Val, old)
+}
+
+// casPre returns the element v as the result of a failed CompareAndSwap, nil if it is missing
+func casPre(v *Value, ok bool) interface{} {
+	if !ok {
+		return nil
+	}
+	return v
+}
+
+// Load innerValue by call loader function, the loaded value is stored unless the
+// loader failed. The loaded result is true if the value was in the cache.
+func (cache *DiskCache) Load(cacheKey string, opts ...EntryOption) (*Value, bool) {
+	eOpts := cache.opts.defaultEntryOpts
+	for _, o := range opts {
+		o(&eOpts)
+	}
+
+	if v, ok := cache.Get(cacheKey); ok || eOpts.loader == nil {
+		return v, ok
+	}
+
+	ret := callLoader(cacheKey, eOpts, cache.opts.clock, nil).innerValue
+	if ret.Err != nil {
+		return ret, false
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	// stored by other goroutine while loading
+	if v, ok := cache.getLocked(cacheKey); ok {
+		return v, true
+	}
+	if err := cache.putLocked(cacheKey, ret.Val, eOpts); err != nil {
+		return &Value{Val: ret.Val, Err: err}, false
+	}
+
+	return ret, false
+}
+
+// Close stops background compaction and closes segment files
+func (cache *DiskCache) Close() error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.closed {
+		return nil
+	}
+	cache.closed = true
+	close(cache.stopChan)
+
+	if err := cache.active.f.Sync(); err != nil {
+		cache.closeFiles()
+		return err
+	}
+	cache.closeFiles()
+	return nil
+}
+
+func (cache *DiskCache) closeFiles() {
+	for _, seg := range cache.segments {
+		_ = seg.f.Close()
+	}
+}
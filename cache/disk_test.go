@@ -0,0 +1,219 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskCache_PutGetDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk_cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cache, err := OpenDiskCache(dir)
+	assert.NoError(t, err)
+	defer cache.Close()
+
+	cache.Put("a", "1")
+	pre := cache.Put("a", "2")
+	assert.Equal(t, "1", pre.(*Value).Val)
+
+	v, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "2", v.Val)
+
+	cur, swapped := cache.CompareAndSwap("a", "1", "3")
+	assert.False(t, swapped)
+	assert.Equal(t, "2", cur.(*Value).Val)
+	_, swapped = cache.CompareAndSwap("a", v, "3")
+	assert.True(t, swapped)
+
+	cache.Delete("a")
+	_, ok = cache.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, cache.Size())
+
+	cache.Put("b", "1", ExpirationOption(time.Millisecond))
+	time.Sleep(2 * time.Millisecond)
+	_, ok = cache.Get("b")
+	assert.False(t, ok)
+}
+
+func TestDiskCache_Load(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk_cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cache, err := OpenDiskCache(dir)
+	assert.NoError(t, err)
+	defer cache.Close()
+
+	calls := 0
+	loader := WithLoader(func(key string) *Value {
+		calls++
+		return &Value{Val: "v_" + key}
+	})
+	v, loaded := cache.Load("a", loader)
+	assert.False(t, loaded)
+	assert.Equal(t, "v_a", v.Val)
+	v, loaded = cache.Load("a", loader)
+	assert.True(t, loaded)
+	assert.Equal(t, "v_a", v.Val)
+	assert.Equal(t, 1, calls)
+}
+
+func TestDiskCache_Recovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk_cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cache, err := OpenDiskCache(dir, DiskSegmentSize(256))
+	assert.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		cache.Put(strconv.Itoa(i), i)
+	}
+	for i := 0; i < 100; i += 2 {
+		cache.Delete(strconv.Itoa(i))
+	}
+	cache.Put("1", "one")
+	assert.NoError(t, cache.Close())
+
+	// simulate a torn write at the tail of the last segment
+	files, _ := ioutil.ReadDir(dir)
+	last := dir + "/" + files[len(files)-1].Name()
+	f, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	_, _ = f.Write([]byte{1, 2, 3, 4, 5})
+	_ = f.Close()
+
+	cache, err = OpenDiskCache(dir, DiskSegmentSize(256))
+	assert.NoError(t, err)
+	defer cache.Close()
+
+	assert.Equal(t, 50, cache.Size())
+	v, ok := cache.Get("1")
+	assert.True(t, ok)
+	assert.Equal(t, "one", v.Val)
+	v, ok = cache.Get("3")
+	assert.True(t, ok)
+	assert.Equal(t, 3, v.Val)
+	_, ok = cache.Get("2")
+	assert.False(t, ok)
+}
+
+func TestDiskCache_CompactAndBudget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk_cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cache, err := OpenDiskCache(dir, DiskSegmentSize(1024), DiskMaxBytes(8*1024))
+	assert.NoError(t, err)
+
+	// overwrite the same keys, compaction keeps the cache within budget
+	for round := 0; round < 50; round++ {
+		for i := 0; i < 10; i++ {
+			cache.Put(strconv.Itoa(i), round)
+		}
+	}
+	assert.True(t, cache.Bytes() <= 8*1024)
+	assert.Equal(t, 10, cache.Size())
+	v, ok := cache.Get("9")
+	assert.True(t, ok)
+	assert.Equal(t, 49, v.Val)
+
+	// unique keys larger than budget, oldest segments are dropped
+	for i := 0; i < 1000; i++ {
+		cache.Put("k"+strconv.Itoa(i), i)
+	}
+	assert.True(t, cache.Bytes() <= 8*1024)
+	_, ok = cache.Get("k999")
+	assert.True(t, ok)
+	_, ok = cache.Get("k0")
+	assert.False(t, ok)
+
+	for i := 0; i < 1000; i += 2 {
+		cache.Delete("k" + strconv.Itoa(i))
+	}
+	assert.NoError(t, cache.Compact())
+	size := cache.Size()
+	assert.NoError(t, cache.Close())
+
+	cache, err = OpenDiskCache(dir, DiskSegmentSize(1024), DiskMaxBytes(8*1024))
+	assert.NoError(t, err)
+	defer cache.Close()
+	assert.Equal(t, size, cache.Size())
+	_, ok = cache.Get("k998")
+	assert.False(t, ok)
+	_, ok = cache.Get("k999")
+	assert.True(t, ok)
+}
+
+func TestDiskCache_CompactExpiredKeepsOlderHidden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk_cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	clock := NewFakeClock(time.Now())
+	open := func() *DiskCache {
+		cache, err := OpenDiskCache(dir, DiskSegmentSize(300), DiskClock(clock))
+		assert.NoError(t, err)
+		return cache
+	}
+	cache := open()
+
+	// the old record stays in a segment with mostly live records
+	cache.Put("k", "old")
+	cache.Put("filler", strings.Repeat("f", 200))
+	// the expiring record is the latest, in a sealed segment
+	cache.Put("k", "new", ExpirationOption(time.Second))
+	cache.Put("rotate", strings.Repeat("r", 250))
+	clock.Advance(2 * time.Second)
+	assert.NoError(t, cache.Compact())
+	_, ok := cache.Get("k")
+	assert.False(t, ok)
+	assert.NoError(t, cache.Close())
+
+	cache = open()
+	defer cache.Close()
+	_, ok = cache.Get("k")
+	assert.False(t, ok)
+	v, ok := cache.Get("filler")
+	assert.True(t, ok)
+	assert.Equal(t, strings.Repeat("f", 200), v.Val)
+}
+
+func TestDiskCache_BudgetLowWater(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk_cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cache, err := OpenDiskCache(dir, DiskSegmentSize(1024), DiskMaxBytes(8*1024))
+	assert.NoError(t, err)
+	defer cache.Close()
+
+	// live records fill the budget
+	n := 0
+	for ; cache.Bytes() < 8*1024-100; n++ {
+		cache.Put("k"+strconv.Itoa(n), n)
+	}
+
+	// overwrites go over budget, the cache is shrunk below the low water mark
+	// instead of compacting on each write
+	enforced := 0
+	for i := 0; i < 1000; i++ {
+		before := cache.Bytes()
+		cache.Put("k"+strconv.Itoa(n-1-i%(n/2)), i)
+		if cache.Bytes() < before {
+			enforced++
+			assert.True(t, cache.Bytes() <= 8*1024*9/10)
+		}
+	}
+	assert.True(t, enforced > 0)
+	assert.True(t, enforced < 100, "enforced %d times", enforced)
+}
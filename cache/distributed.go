@@ -0,0 +1,615 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"goober/cache/v2"
+)
+
+const (
+	defaultDistributedBasePath      = "/_goober/"
+	defaultDistributedExpiration    = time.Minute
+	defaultDistributedHotRate       = 0.1
+	defaultDistributedHotExpiration = 10 * time.Second
+	defaultDistributedTimeout       = 3 * time.Second
+	defaultRingReplicas             = 50
+
+	casOldHeader = "X-Goober-Cas-Old"
+	// replacedHeader is set in the response of a put that replaced an element, or of a
+	// compare and swap that failed on an existing element, with the existing value as
+	// body or its error in prevErrHeader
+	replacedHeader = "X-Goober-Replaced"
+	prevErrHeader  = "X-Goober-Previous-Err"
+)
+
+var (
+	// ErrNotFound the key does not exist and there is no loader
+	ErrNotFound = errors.New("key not found")
+)
+
+// DistributedOptions options for distributed cache
+type DistributedOptions struct {
+	basePath string
+
+	loader Loader
+	codec  Codec
+
+	expiration time.Duration
+
+	// hot keys fetched from other peers are mirrored with probability hotRate
+	hotRate       float64
+	hotExpiration time.Duration
+
+	localOpts []Option
+	client    *http.Client
+}
+
+// DistributedOption ...
+type DistributedOption func(options *DistributedOptions)
+
+// NewDistributedOptions new a distributed options
+func NewDistributedOptions() DistributedOptions {
+	return DistributedOptions{
+		basePath:      defaultDistributedBasePath,
+		codec:         GobCodec{},
+		expiration:    defaultDistributedExpiration,
+		hotRate:       defaultDistributedHotRate,
+		hotExpiration: defaultDistributedHotExpiration,
+		client:        &http.Client{Timeout: defaultDistributedTimeout},
+	}
+}
+
+// DistributedBasePath set the http path prefix served by peers
+func DistributedBasePath(p string) DistributedOption {
+	return func(options *DistributedOptions) {
+		options.basePath = p
+	}
+}
+
+// DistributedLoader set the loader run by the owner of a key
+func DistributedLoader(loader Loader) DistributedOption {
+	return func(options *DistributedOptions) {
+		options.loader = loader
+	}
+}
+
+// DistributedCodec set the codec of values sent between peers
+func DistributedCodec(c Codec) DistributedOption {
+	return func(options *DistributedOptions) {
+		options.codec = c
+	}
+}
+
+// DistributedExpiration set expiration of entries stored by owners
+func DistributedExpiration(d time.Duration) DistributedOption {
+	return func(options *DistributedOptions) {
+		options.expiration = d
+	}
+}
+
+// HotMirror set the probability of mirroring a value fetched from its owner and the
+// expiration of mirrored values, rate 0 disables mirroring.
+func HotMirror(rate float64, expiration time.Duration) DistributedOption {
+	return func(options *DistributedOptions) {
+		options.hotRate = rate
+		options.hotExpiration = expiration
+	}
+}
+
+// LocalOptions set options of the local LRUCache stores
+func LocalOptions(opts ...Option) DistributedOption {
+	return func(options *DistributedOptions) {
+		options.localOpts = append(options.localOpts, opts...)
+	}
+}
+
+// HTTPClient set the http client used to reach peers
+func HTTPClient(c *http.Client) DistributedOption {
+	return func(options *DistributedOptions) {
+		options.client = c
+	}
+}
+
+// DistributedCache is a groupcache-style peer to peer Cache. Each key is owned by
+// one peer chosen by a consistent hash ring, the owner stores the key and runs the
+// loader once however many peers ask for it concurrently. Other peers fetch the key
+// from the owner over http, and mirror hot keys locally for a short time.
+//
+// Peers are identified by their base url, e.g. "http://10.0.0.1:8080", and each peer
+// must serve the cache as an http.Handler under the base path. Membership is set by
+// SetPeers, there is no discovery.
+//
+// Mirrored copies on other peers are not invalidated by Put or Delete, they expire
+// after the hot expiration.
+type DistributedCache struct {
+	name string
+	self string
+
+	ring *v2.ConsistentHash
+
+	// local stores keys owned by self, hot mirrors keys owned by other peers
+	local *LRUCache
+	hot   *LRUCache
+
+	loads   flightGroup
+	fetches flightGroup
+
+	opts DistributedOptions
+}
+
+// NewDistributedCache new a distributed cache named name on the peer self
+func NewDistributedCache(name, self string, opt ...DistributedOption) *DistributedCache {
+	opts := NewDistributedOptions()
+	for _, o := range opt {
+		o(&opts)
+	}
+
+	c := &DistributedCache{
+		name:  name,
+		self:  self,
+		ring:  v2.NewConsistentHash(defaultRingReplicas),
+		local: NewLRUCache(opts.localOpts...),
+		hot:   NewLRUCache(opts.localOpts...),
+		opts:  opts,
+	}
+	c.ring.Add(self, 1)
+
+	return c
+}
+
+// SetPeers sets the base urls of all peers, including self.
+// Only keys of added or removed peers change their owners.
+func (c *DistributedCache) SetPeers(peers ...string) {
+	cur := make(map[string]bool)
+	for _, peer := range c.ring.Nodes() {
+		cur[peer] = true
+	}
+
+	keep := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		keep[peer] = true
+		if !cur[peer] {
+			c.ring.Add(peer, 1)
+		}
+	}
+	for peer := range cur {
+		if !keep[peer] {
+			c.ring.Remove(peer)
+		}
+	}
+}
+
+func (c *DistributedCache) owner(key string) string {
+	peer, _ := c.ring.Get(key)
+	return peer
+}
+
+// Get retrieves an element from its owner without calling the loader
+func (c *DistributedCache) Get(key string) (*Value, bool) {
+	return c.get(key, false)
+}
+
+// Load retrieves an element from its owner, the owner calls the loader if the element
+// is missing. opts are only used when self owns the key, otherwise the owner's
+// DistributedLoader and DistributedExpiration are used.
+// The loaded result is true if the value was in the owner's cache, or shared with a
+// concurrent call of the same key.
+func (c *DistributedCache) Load(cacheKey string, opts ...EntryOption) (*Value, bool) {
+	if c.owner(cacheKey) == c.self {
+		return c.loadLocal(cacheKey, c.loadOpts(opts))
+	}
+	return c.get(cacheKey, true)
+}
+
+func (c *DistributedCache) get(key string, load bool) (*Value, bool) {
+	owner := c.owner(key)
+	if owner == c.self {
+		if !load {
+			return c.local.getUnexpired(key)
+		}
+		return c.loadLocal(key, c.loadOpts(nil))
+	}
+
+	if v, ok := c.hot.getUnexpired(key); ok {
+		return v, true
+	}
+
+	// gets and loads of a key fetch separately, a load must not share the ErrNotFound of a get
+	flightKey := "get:" + key
+	if load {
+		flightKey = "load:" + key
+	}
+	loaded := true
+	v, _ := c.fetches.do(flightKey, func() *Value {
+		var v *Value
+		v, loaded = c.fetch(owner, key, load)
+		if v.Err == nil && c.opts.hotRate > 0 && rand.Float64() < c.opts.hotRate {
+			c.hot.Put(key, v.Val, ExpirationOption(c.opts.hotExpiration))
+		}
+		return v
+	})
+
+	if errors.Is(v.Err, ErrNotFound) {
+		return nil, false
+	}
+	var peerErr *peerError
+	if load && errors.As(v.Err, &peerErr) {
+		// the owner is unreachable, load locally instead
+		return c.loadLocal(key, c.loadOpts(nil))
+	}
+	return v, loaded
+}
+
+// loadOpts returns entry options of loads, with the DistributedLoader and DistributedExpiration
+// unless set by opts
+func (c *DistributedCache) loadOpts(opts []EntryOption) EntryOptions {
+	eOpts := NewEntryOptions()
+	eOpts.loader = c.opts.loader
+	eOpts.expireAfterWrite = c.opts.expiration
+	for _, o := range opts {
+		o(&eOpts)
+	}
+	return eOpts
+}
+
+// loadLocal loads key owned by self, concurrent loads of the same key call the loader once
+func (c *DistributedCache) loadLocal(key string, eOpts EntryOptions) (*Value, bool) {
+	if v, ok := c.local.getUnexpired(key); ok {
+		return v, true
+	}
+	if eOpts.loader == nil {
+		return nil, false
+	}
+
+	v, shared := c.loads.do(key, func() *Value {
+		if v, ok := c.local.getUnexpired(key); ok {
+			return v
+		}
+
+		v := c.local.callLoader(key, eOpts).innerValue
+		if v.Err == nil {
+			c.local.Put(key, v.Val, ExpirationOption(eOpts.expireAfterWrite))
+		}
+		return v
+	})
+
+	return v, shared
+}
+
+// Put stores an element at its owner, returning the previous element at the owner
+// if the key existed, otherwise the new element
+func (c *DistributedCache) Put(key string, value interface{}, opts ...EntryOption) interface{} {
+	owner := c.owner(key)
+	if owner == c.self {
+		return c.local.Put(key, value, c.entryOpts(opts)...)
+	}
+
+	c.hot.Delete(key)
+	header, body, err := c.send(http.MethodPut, owner, key, value, nil)
+	if err != nil {
+		return &Value{Err: err}
+	}
+	if header.Get(replacedHeader) == "" {
+		return &Value{Val: value}
+	}
+	return c.decodePrevious(header, body)
+}
+
+// decodePrevious decodes the existing element sent in a response with replacedHeader
+func (c *DistributedCache) decodePrevious(header http.Header, body []byte) *Value {
+	if prevErr := header.Get(prevErrHeader); prevErr != "" {
+		return &Value{Err: errors.New(prevErr)}
+	}
+	pre, err := c.opts.codec.Unmarshal(body)
+	return &Value{Val: pre, Err: err}
+}
+
+// Delete deletes an element at its owner
+func (c *DistributedCache) Delete(key string) {
+	c.hot.Delete(key)
+
+	owner := c.owner(key)
+	if owner == c.self {
+		c.local.Delete(key)
+		return
+	}
+	_, _, _ = c.send(http.MethodDelete, owner, key, nil, nil)
+}
+
+// Size returns the number of entries owned by self
+func (c *DistributedCache) Size() int {
+	return c.local.Size()
+}
+
+// CompareAndSwap swaps the element at its owner if the existing value deeply equals the value
+// of old, a nil old matches a missing element. Elements are copies of the owner's, so an old
+// *Value returned by Get matches by its Val instead of identity.
+// It returns the existing element, nil if missing, and false if not swapped.
+func (c *DistributedCache) CompareAndSwap(key string, old, new interface{}, opts ...EntryOption) (interface{}, bool) {
+	owner := c.owner(key)
+	if owner == c.self {
+		return c.compareAndSwapLocal(key, old, new, opts)
+	}
+
+	c.hot.Delete(key)
+	if o, ok := old.(*Value); ok && o != nil {
+		old = o.Val
+	}
+	var cur interface{}
+	_, _, err := c.send(http.MethodPut, owner, key, new, &casRequest{old: old, cur: &cur})
+	if err != nil {
+		return cur, false
+	}
+	return new, true
+}
+
+// compareAndSwapLocal compares the value of the local element with old, and swaps the element
+// by LRUCache.CompareAndSwap if it is not changed meanwhile, comparing again otherwise.
+func (c *DistributedCache) compareAndSwapLocal(key string, old, new interface{}, opts []EntryOption) (interface{}, bool) {
+	for {
+		cur, live := c.local.peek(key)
+		if !casValueMatch(cur, live, old) {
+			return casPre(cur, live), false
+		}
+
+		// an expired element matches a nil old, and is swapped by identity like a live one
+		var expect interface{}
+		if cur != nil {
+			expect = cur
+		}
+		if _, swapped := c.local.CompareAndSwap(key, expect, new, c.entryOpts(opts)...); swapped {
+			return new, true
+		}
+	}
+}
+
+func (c *DistributedCache) entryOpts(opts []EntryOption) []EntryOption {
+	return append([]EntryOption{ExpirationOption(c.opts.expiration)}, opts...)
+}
+
+// peerError the owner of a key could not be reached
+type peerError struct {
+	peer string
+	err  error
+}
+
+func (e *peerError) Error() string {
+	return fmt.Sprintf("peer %s: %v", e.peer, e.err)
+}
+
+func (e *peerError) Unwrap() error {
+	return e.err
+}
+
+type casRequest struct {
+	old interface{}
+	// cur is set to the existing element if not swapped
+	cur *interface{}
+}
+
+// errCasFailed the compare of CompareAndSwap failed at the owner
+var errCasFailed = errors.New("compare and swap failed")
+
+func (c *DistributedCache) keyURL(peer, key string) string {
+	return strings.TrimSuffix(peer, "/") + c.opts.basePath + url.PathEscape(c.name) + "/" + url.PathEscape(key)
+}
+
+// fetch gets key from its owner
+func (c *DistributedCache) fetch(owner, key string, load bool) (*Value, bool) {
+	u := c.keyURL(owner, key)
+	if load {
+		u += "?load=1"
+	}
+
+	rsp, err := c.opts.client.Get(u)
+	if err != nil {
+		return &Value{Err: &peerError{peer: owner, err: err}}, false
+	}
+	defer rsp.Body.Close()
+
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return &Value{Err: &peerError{peer: owner, err: err}}, false
+	}
+
+	switch rsp.StatusCode {
+	case http.StatusOK:
+		val, err := c.opts.codec.Unmarshal(body)
+		loaded, _ := strconv.ParseBool(rsp.Header.Get("X-Goober-Loaded"))
+		return &Value{Val: val, Err: err}, loaded
+	case http.StatusNotFound:
+		return &Value{Err: ErrNotFound}, false
+	case http.StatusInternalServerError:
+		// loader failed at the owner
+		return &Value{Err: errors.New(string(body))}, false
+	default:
+		return &Value{Err: &peerError{peer: owner, err: errors.New(rsp.Status)}}, false
+	}
+}
+
+// send sends a write of key to its owner, returning the header and body of the response
+func (c *DistributedCache) send(method, owner, key string, value interface{}, cas *casRequest) (http.Header, []byte, error) {
+	var body []byte
+	if method == http.MethodPut {
+		var err error
+		if body, err = c.opts.codec.Marshal(value); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.keyURL(owner, key), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	if cas != nil {
+		old, err := c.opts.codec.Marshal(cas.old)
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header.Set(casOldHeader, base64.StdEncoding.EncodeToString(old))
+	}
+
+	rsp, err := c.opts.client.Do(req)
+	if err != nil {
+		return nil, nil, &peerError{peer: owner, err: err}
+	}
+	defer rsp.Body.Close()
+	rspBody, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, nil, &peerError{peer: owner, err: err}
+	}
+
+	switch rsp.StatusCode {
+	case http.StatusOK:
+		return rsp.Header, rspBody, nil
+	case http.StatusConflict:
+		if cas != nil && rsp.Header.Get(replacedHeader) != "" {
+			*cas.cur = c.decodePrevious(rsp.Header, rspBody)
+		}
+		return nil, nil, errCasFailed
+	default:
+		return nil, nil, &peerError{peer: owner, err: errors.New(rsp.Status)}
+	}
+}
+
+// ServeHTTP serves requests of other peers for keys owned by self
+func (c *DistributedCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.EscapedPath(), c.opts.basePath)
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) != 2 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	name, err1 := url.PathUnescape(parts[0])
+	key, err2 := url.PathUnescape(parts[1])
+	if err1 != nil || err2 != nil || name != c.name {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		c.serveGet(w, r, key)
+	case http.MethodPut:
+		c.servePut(w, r, key)
+	case http.MethodDelete:
+		c.local.Delete(key)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *DistributedCache) serveGet(w http.ResponseWriter, r *http.Request, key string) {
+	var (
+		v      *Value
+		loaded bool
+	)
+	if r.URL.Query().Get("load") != "" {
+		v, loaded = c.loadLocal(key, c.loadOpts(nil))
+	} else {
+		v, loaded = c.local.getUnexpired(key)
+	}
+	if v == nil {
+		http.Error(w, ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if v.Err != nil {
+		http.Error(w, v.Err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := c.opts.codec.Marshal(v.Val)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Goober-Loaded", strconv.FormatBool(loaded))
+	_, _ = w.Write(body)
+}
+
+func (c *DistributedCache) servePut(w http.ResponseWriter, r *http.Request, key string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	val, err := c.opts.codec.Unmarshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	casOld := r.Header.Get(casOldHeader)
+	if casOld == "" {
+		c.servePlainPut(w, key, val)
+		return
+	}
+
+	oldBody, err := base64.StdEncoding.DecodeString(casOld)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	old, err := c.opts.codec.Unmarshal(oldBody)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cur, swapped := c.compareAndSwapLocal(key, old, val, nil)
+	if swapped {
+		return
+	}
+	if cur == nil {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	curValue := cur.(*Value)
+	if curValue.Err != nil {
+		w.Header().Set(replacedHeader, "true")
+		w.Header().Set(prevErrHeader, curValue.Err.Error())
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	curBody, err := c.opts.codec.Marshal(curValue.Val)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(replacedHeader, "true")
+	w.WriteHeader(http.StatusConflict)
+	_, _ = w.Write(curBody)
+}
+
+// servePlainPut stores val, responding the previous element if the key existed
+func (c *DistributedCache) servePlainPut(w http.ResponseWriter, key string, val interface{}) {
+	pre, replaced := c.local.replace(key, val, ExpirationOption(c.opts.expiration))
+	if !replaced {
+		return
+	}
+
+	w.Header().Set(replacedHeader, "true")
+	preValue := pre.(*Value)
+	if preValue.Err != nil {
+		w.Header().Set(prevErrHeader, preValue.Err.Error())
+		return
+	}
+	body, err := c.opts.codec.Marshal(preValue.Val)
+	if err != nil {
+		// stored, the previous value is not sent
+		w.Header().Set(prevErrHeader, err.Error())
+		return
+	}
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,247 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestPeers starts n peers of a distributed cache on loopback
+func newTestPeers(t *testing.T, n int, opts ...DistributedOption) ([]*DistributedCache, func()) {
+	servers := make([]*httptest.Server, n)
+	caches := make([]*DistributedCache, n)
+	handlers := make([]http.Handler, n)
+	urls := make([]string, n)
+
+	for i := 0; i < n; i++ {
+		i := i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+		urls[i] = servers[i].URL
+	}
+	for i := 0; i < n; i++ {
+		caches[i] = NewDistributedCache("test", urls[i], opts...)
+		caches[i].SetPeers(urls...)
+		handlers[i] = caches[i]
+	}
+
+	return caches, func() {
+		for _, s := range servers {
+			s.Close()
+		}
+	}
+}
+
+func TestDistributedCache_Load(t *testing.T) {
+	var calls int64
+	loader := func(key string) *Value {
+		atomic.AddInt64(&calls, 1)
+		return &Value{Val: "v_" + key}
+	}
+	peers, stop := newTestPeers(t, 3, DistributedLoader(loader), HotMirror(0, 0))
+	defer stop()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for k := 0; k < 20; k++ {
+				key := strconv.Itoa(k)
+				v, _ := peers[i%3].Load(key)
+				assert.NoError(t, v.Err)
+				assert.Equal(t, "v_"+key, v.Val)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// each key is loaded once by its owner
+	assert.Equal(t, int64(20), atomic.LoadInt64(&calls))
+	size := 0
+	for _, p := range peers {
+		size += p.Size()
+	}
+	assert.Equal(t, 20, size)
+}
+
+func TestDistributedCache_PutDelete(t *testing.T) {
+	peers, stop := newTestPeers(t, 3, HotMirror(0, 0))
+	defer stop()
+
+	for k := 0; k < 10; k++ {
+		key := fmt.Sprintf("k%d", k)
+		peers[k%3].Put(key, k)
+		for _, p := range peers {
+			v, ok := p.Get(key)
+			assert.True(t, ok)
+			assert.Equal(t, k, v.Val)
+		}
+
+		cur, swapped := peers[(k+1)%3].CompareAndSwap(key, k+1, -1)
+		assert.False(t, swapped)
+		assert.Equal(t, &Value{Val: k}, cur)
+		v, _ := peers[(k+1)%3].Get(key)
+		_, swapped = peers[(k+1)%3].CompareAndSwap(key, v, -1)
+		assert.True(t, swapped)
+		v, _ = peers[(k+2)%3].Get(key)
+		assert.Equal(t, -1, v.Val)
+
+		peers[(k+2)%3].Delete(key)
+		for _, p := range peers {
+			_, ok := p.Get(key)
+			assert.False(t, ok)
+		}
+		cur, swapped = peers[k%3].CompareAndSwap(key, v, 0)
+		assert.False(t, swapped)
+		assert.Nil(t, cur)
+	}
+}
+
+func TestDistributedCache_PutReturnsPrevious(t *testing.T) {
+	peers, stop := newTestPeers(t, 3, HotMirror(0, 0))
+	defer stop()
+
+	// the same result whether the key is owned by the caller or another peer
+	for k := 0; k < 10; k++ {
+		key := fmt.Sprintf("k%d", k)
+		for i, p := range peers {
+			p.Delete(key)
+			assert.Equal(t, i, p.Put(key, i).(*Value).Val)
+			assert.Equal(t, i, p.Put(key, i+10).(*Value).Val)
+		}
+	}
+}
+
+func TestDistributedCache_HotMirrorAndOwnerDown(t *testing.T) {
+	var calls int64
+	loader := func(key string) *Value {
+		atomic.AddInt64(&calls, 1)
+		return &Value{Val: key}
+	}
+	peers, stop := newTestPeers(t, 2, DistributedLoader(loader), HotMirror(1, time.Minute))
+
+	// find keys owned by peer 1
+	remoteKey := func(from int) string {
+		for k := from; ; k++ {
+			key := strconv.Itoa(k)
+			if peers[0].owner(key) == peers[1].self {
+				return key
+			}
+		}
+	}
+	key := remoteKey(0)
+
+	v, _ := peers[0].Load(key)
+	assert.Equal(t, key, v.Val)
+	_, ok := peers[0].hot.Get(key)
+	assert.True(t, ok)
+
+	// owner down, load locally
+	stop()
+	downKey := remoteKey(1000)
+	assert.NotEqual(t, peers[0].self, peers[0].owner(downKey))
+	v, _ = peers[0].Load(downKey)
+	assert.NoError(t, v.Err)
+	assert.Equal(t, downKey, v.Val)
+	_, ok = peers[0].local.Get(downKey)
+	assert.True(t, ok)
+}
+
+func TestDistributedCache_LoadNotSharingGet(t *testing.T) {
+	loader := func(key string) *Value {
+		return &Value{Val: "v_" + key}
+	}
+
+	// the owner blocks gets without load until released
+	getting, release := make(chan struct{}, 1), make(chan struct{})
+	var owner *DistributedCache
+	ownerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Query().Get("load") == "" {
+			getting <- struct{}{}
+			<-release
+		}
+		owner.ServeHTTP(w, r)
+	}))
+	defer ownerServer.Close()
+	selfServer := httptest.NewServer(http.NotFoundHandler())
+	defer selfServer.Close()
+
+	opts := []DistributedOption{DistributedLoader(loader), HotMirror(0, 0)}
+	owner = NewDistributedCache("test", ownerServer.URL, opts...)
+	owner.SetPeers(ownerServer.URL, selfServer.URL)
+	self := NewDistributedCache("test", selfServer.URL, opts...)
+	self.SetPeers(ownerServer.URL, selfServer.URL)
+
+	key := ""
+	for k := 0; ; k++ {
+		key = strconv.Itoa(k)
+		if self.owner(key) == ownerServer.URL {
+			break
+		}
+	}
+
+	// a get of the key in flight, not finding it
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		self.Get(key)
+	}()
+	<-getting
+
+	loadDone := make(chan *Value)
+	go func() {
+		v, _ := self.Load(key)
+		loadDone <- v
+	}()
+	select {
+	case v := <-loadDone:
+		assert.NoError(t, v.Err)
+		assert.Equal(t, "v_"+key, v.Val)
+	case <-time.After(time.Second):
+		t.Error("load waited for the get in flight")
+	}
+	close(release)
+	<-done
+}
+
+func TestDistributedCache_CompareAndSwapAtomic(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	c := NewDistributedCache("test", "http://127.0.0.1:0", LocalOptions(WithClock(clock)))
+
+	// a delete is never lost between the compare and the swap
+	for i := 0; i < 2000; i++ {
+		c.Put("k", 0)
+		wg := sync.WaitGroup{}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			c.CompareAndSwap("k", 0, 1)
+		}()
+		go func() {
+			defer wg.Done()
+			c.Delete("k")
+		}()
+		wg.Wait()
+
+		if _, ok := c.Get("k"); ok {
+			t.Fatalf("deleted key swapped in round %d", i)
+		}
+	}
+
+	// an expired element matches a nil old
+	c.Put("e", 0, ExpirationOption(time.Second))
+	clock.Advance(2 * time.Second)
+	_, swapped := c.CompareAndSwap("e", nil, 1)
+	assert.True(t, swapped)
+	v, _ := c.Get("e")
+	assert.Equal(t, 1, v.Val)
+}
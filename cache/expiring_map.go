@@ -0,0 +1,334 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	defaultExpiringSweepInterval = time.Minute
+	defaultExpiringSweepEvict    = 32
+)
+
+// ExpiringMapOptions options for expiring map
+type ExpiringMapOptions struct {
+	// sweepInterval interval of the background sweeper removing expired entries
+	sweepInterval time.Duration
+
+	// maxSize bound of the number of entries, no bound if 0
+	maxSize int
+
+	// clock provides time for expiration and sweeping
+	clock Clock
+
+	// default options for each kv entry
+	defaultEntryOpts EntryOptions
+}
+
+// ExpiringMapOption ...
+type ExpiringMapOption func(options *ExpiringMapOptions)
+
+// NewExpiringMapOptions new an expiring map options
+func NewExpiringMapOptions() ExpiringMapOptions {
+	return ExpiringMapOptions{
+		sweepInterval:    defaultExpiringSweepInterval,
+		clock:            SystemClock{},
+		defaultEntryOpts: NewEntryOptions(),
+	}
+}
+
+// ExpiringSweepInterval set the interval of the background sweeper
+func ExpiringSweepInterval(t time.Duration) ExpiringMapOption {
+	return func(options *ExpiringMapOptions) {
+		options.sweepInterval = t
+	}
+}
+
+// ExpiringMaxSize set the bound of the number of entries. When a new key is put into a full map,
+// expired entries are swept, then arbitrary entries are removed if it is still full.
+func ExpiringMaxSize(v int) ExpiringMapOption {
+	return func(options *ExpiringMapOptions) {
+		options.maxSize = v
+	}
+}
+
+// ExpiringClock set the clock of the map
+func ExpiringClock(c Clock) ExpiringMapOption {
+	return func(options *ExpiringMapOptions) {
+		options.clock = c
+	}
+}
+
+// ExpiringDefaultEntryOpts set default options for each kv entry
+func ExpiringDefaultEntryOpts(opts ...EntryOption) ExpiringMapOption {
+	return func(options *ExpiringMapOptions) {
+		eOpts := options.defaultEntryOpts
+		for _, eOpt := range opts {
+			eOpt(&eOpts)
+		}
+		options.defaultEntryOpts = eOpts
+	}
+}
+
+// ExpiringMap is a Cache on Map for read-heavy workloads: Get and Load of present keys
+// take the lock-free read path of Map, while writes are serialized by a mutex.
+//
+// Like LRUCache, expired entries are still returned by Get and refreshed by Load until
+// the background sweeper removes them.
+type ExpiringMap struct {
+	// m maps keys to *internalEntry, entries are replaced and never modified
+	m Map
+
+	// mu serializes writes, so size stays exact
+	mu   sync.Mutex
+	size *atomic.Int64
+
+	flight flightGroup
+
+	closeOnce sync.Once
+	stopChan  chan struct{}
+
+	opts ExpiringMapOptions
+}
+
+// NewExpiringMap new an expiring map, Close stops its sweeper
+func NewExpiringMap(opt ...ExpiringMapOption) *ExpiringMap {
+	opts := NewExpiringMapOptions()
+	for _, o := range opt {
+		o(&opts)
+	}
+
+	em := &ExpiringMap{
+		size:     atomic.NewInt64(0),
+		stopChan: make(chan struct{}),
+		opts:     opts,
+	}
+	em.m.SetClock(opts.clock)
+
+	// created before returning, so the first sweep is due sweepInterval after NewExpiringMap
+	go em.asyncSweep(newHandledTicker(opts.clock, opts.sweepInterval))
+
+	return em
+}
+
+func (em *ExpiringMap) asyncSweep(t Ticker) {
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C():
+			em.Sweep()
+			tickHandled(t)
+		case <-em.stopChan:
+			return
+		}
+	}
+}
+
+// Close stops the background sweeper
+func (em *ExpiringMap) Close() {
+	em.closeOnce.Do(func() {
+		close(em.stopChan)
+	})
+}
+
+// Sweep removes expired entries, returning the number removed
+func (em *ExpiringMap) Sweep() int {
+	now := em.opts.clock.Now().UnixNano()
+	var expired []*internalEntry
+	em.m.Range(func(key, value interface{}) bool {
+		if entry := value.(*internalEntry); entry.expiration <= now {
+			expired = append(expired, entry)
+		}
+		return true
+	})
+
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	n := 0
+	for _, entry := range expired {
+		// the entry may be replaced since ranged
+		if cur, ok := em.m.Load(entry.key); ok && cur.(*internalEntry) == entry {
+			em.deleteLocked(entry.key)
+			n++
+		}
+	}
+	return n
+}
+
+func (em *ExpiringMap) entry(key string) (*internalEntry, bool) {
+	v, ok := em.m.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(*internalEntry), true
+}
+
+func (em *ExpiringMap) newEntry(key string, value interface{}, eOpts EntryOptions) *internalEntry {
+	return &internalEntry{
+		key:        key,
+		innerValue: &Value{Val: value},
+		expiration: em.opts.clock.Now().Add(eOpts.expireAfterWrite).UnixNano(),
+		refreshed:  atomic.NewBool(false),
+	}
+}
+
+// storeLocked stores entry, making room for a new key if the map is full
+func (em *ExpiringMap) storeLocked(entry *internalEntry) {
+	if _, ok := em.m.Load(entry.key); !ok {
+		em.makeRoomLocked()
+		em.size.Inc()
+	}
+	em.m.Store(entry.key, entry)
+}
+
+func (em *ExpiringMap) deleteLocked(key string) {
+	if _, ok := em.m.Load(key); ok {
+		em.m.Delete(key)
+		em.size.Dec()
+	}
+}
+
+// makeRoomLocked removes expired entries, then arbitrary entries if the map is still full
+func (em *ExpiringMap) makeRoomLocked() {
+	if em.opts.maxSize <= 0 || int(em.size.Load()) < em.opts.maxSize {
+		return
+	}
+
+	now := em.opts.clock.Now().UnixNano()
+	var keys []string
+	em.m.Range(func(key, value interface{}) bool {
+		if value.(*internalEntry).expiration <= now {
+			keys = append(keys, key.(string))
+		}
+		return true
+	})
+	if len(keys) == 0 {
+		em.m.Range(func(key, value interface{}) bool {
+			keys = append(keys, key.(string))
+			return len(keys) < defaultExpiringSweepEvict
+		})
+	}
+	for _, key := range keys {
+		em.deleteLocked(key)
+	}
+}
+
+// Get returns the element of key even if it has expired, see Load to refresh it
+func (em *ExpiringMap) Get(key string) (*Value, bool) {
+	entry, ok := em.entry(key)
+	if !ok {
+		return nil, false
+	}
+	return entry.innerValue, true
+}
+
+// Put adds an element, returning the previous element if the key existed, otherwise the new element
+func (em *ExpiringMap) Put(key string, value interface{}, opts ...EntryOption) interface{} {
+	eOpts := em.opts.defaultEntryOpts
+	for _, o := range opts {
+		o(&eOpts)
+	}
+	entry := em.newEntry(key, value, eOpts)
+
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	pre, ok := em.entry(key)
+	em.storeLocked(entry)
+	if ok {
+		return pre.innerValue
+	}
+	return entry.innerValue
+}
+
+func (em *ExpiringMap) Delete(key string) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	em.deleteLocked(key)
+}
+
+// Size returns the number of entries, including expired entries not swept yet
+func (em *ExpiringMap) Size() int {
+	return int(em.size.Load())
+}
+
+func (em *ExpiringMap) CompareAndSwap(key string, old, new interface{}, opts ...EntryOption) (interface{}, bool) {
+	eOpts := em.opts.defaultEntryOpts
+	for _, o := range opts {
+		o(&eOpts)
+	}
+
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	var pre interface{}
+	if entry, ok := em.entry(key); ok {
+		pre = entry.innerValue
+	}
+	if pre != old {
+		return pre, false
+	}
+
+	em.storeLocked(em.newEntry(key, new, eOpts))
+	return new, true
+}
+
+// Load returns the element of key, calling the loader if it is missing, or expired with SyncLoad.
+// An expired element is returned and refreshed asynchronously without SyncLoad.
+// Concurrent loads of a key call the loader once.
+func (em *ExpiringMap) Load(key string, opts ...EntryOption) (*Value, bool) {
+	eOpts := em.opts.defaultEntryOpts
+	for _, o := range opts {
+		o(&eOpts)
+	}
+
+	// loader function is nil, then return Get(key) innerValue
+	if eOpts.loader == nil {
+		return em.Get(key)
+	}
+
+	if entry, ok := em.entry(key); ok {
+		expired := entry.expiration <= em.opts.clock.Now().UnixNano()
+		if !expired {
+			return entry.innerValue, true
+		}
+		if !eOpts.syncLoad {
+			if entry.refreshed.CAS(false, true) {
+				go em.refresh(entry, eOpts)
+			}
+			return entry.innerValue, true
+		}
+	}
+
+	loaded := true
+	v, _ := em.flight.do(key, func() *Value {
+		// loaded by the previous flight
+		if entry, ok := em.entry(key); ok && entry.expiration > em.opts.clock.Now().UnixNano() {
+			return entry.innerValue
+		}
+
+		loaded = false
+		entry := callLoader(key, eOpts, em.opts.clock, nil)
+		em.mu.Lock()
+		em.storeLocked(entry)
+		em.mu.Unlock()
+		return entry.innerValue
+	})
+	return v, loaded
+}
+
+// refresh reloads the expired entry stale, unless it has been replaced or deleted meanwhile
+func (em *ExpiringMap) refresh(stale *internalEntry, eOpts EntryOptions) {
+	entry := callLoader(stale.key, eOpts, em.opts.clock, nil)
+
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	if cur, ok := em.entry(stale.key); ok && cur == stale {
+		em.storeLocked(entry)
+	}
+}
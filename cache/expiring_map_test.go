@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiringMap_Sweep(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	m := NewExpiringMap(ExpiringClock(clock), ExpiringSweepInterval(time.Minute))
+	defer m.Close()
+
+	m.Put("short", 1, ExpirationOption(time.Second))
+	m.Put("long", 2, ExpirationOption(time.Hour))
+	assert.Equal(t, 2, m.Size())
+
+	// expired entries are served until swept
+	clock.Advance(2 * time.Second)
+	v, ok := m.Get("short")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v.Val)
+	m.Put("long", 3, ExpirationOption(time.Hour))
+
+	// the background sweeper removes them
+	clock.Advance(time.Minute)
+	assert.Equal(t, 1, m.Size())
+	_, ok = m.Get("short")
+	assert.False(t, ok)
+	v, _ = m.Get("long")
+	assert.Equal(t, 3, v.Val)
+
+	assert.Equal(t, 0, m.Sweep())
+}
+
+func TestExpiringMap_MaxSize(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	m := NewExpiringMap(ExpiringClock(clock), ExpiringMaxSize(4))
+	defer m.Close()
+
+	m.Put("expired", 0, ExpirationOption(time.Second))
+	for i := 0; i < 3; i++ {
+		m.Put(fmt.Sprint(i), i, ExpirationOption(time.Hour))
+	}
+	clock.Advance(2 * time.Second)
+
+	// the expired entry makes room first
+	m.Put("3", 3, ExpirationOption(time.Hour))
+	assert.Equal(t, 4, m.Size())
+	_, ok := m.Get("expired")
+	assert.False(t, ok)
+
+	// then arbitrary entries
+	for i := 4; i < 20; i++ {
+		m.Put(fmt.Sprint(i), i, ExpirationOption(time.Hour))
+		assert.True(t, m.Size() <= 4)
+	}
+	v, ok := m.Get("19")
+	assert.True(t, ok)
+	assert.Equal(t, 19, v.Val)
+
+	// replacing does not evict
+	m.Put("19", 190, ExpirationOption(time.Hour))
+	assert.Equal(t, 4, m.Size())
+}
+
+func TestExpiringMap_LoadOnce(t *testing.T) {
+	m := NewExpiringMap()
+	defer m.Close()
+
+	var calls int32
+	release := make(chan struct{})
+	loader := WithLoader(func(key string) *Value {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &Value{Val: "loaded"}
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, _ := m.Load("k", loader, ExpirationOption(time.Hour))
+			assert.Equal(t, "loaded", v.Val)
+		}()
+	}
+	eventually(t, func() bool { return atomic.LoadInt32(&calls) == 1 })
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, 1, m.Size())
+}
+
+func TestExpiringMap_AsyncRefresh(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	m := NewExpiringMap(ExpiringClock(clock), ExpiringSweepInterval(time.Hour))
+	defer m.Close()
+
+	var calls int32
+	loader := WithLoader(func(key string) *Value {
+		return &Value{Val: atomic.AddInt32(&calls, 1)}
+	})
+
+	v, loaded := m.Load("k", loader, ExpirationOption(time.Minute))
+	assert.False(t, loaded)
+	assert.Equal(t, int32(1), v.Val)
+
+	// expired, the stale value is returned while refreshing
+	clock.Advance(time.Minute)
+	v, loaded = m.Load("k", loader, ExpirationOption(time.Minute))
+	assert.True(t, loaded)
+	assert.Equal(t, int32(1), v.Val)
+	eventually(t, func() bool {
+		v, _ := m.Get("k")
+		return v.Val == int32(2)
+	})
+
+	// a refresh does not undo a delete
+	clock.Advance(time.Minute)
+	m.Load("k", loader, ExpirationOption(time.Minute))
+	m.Delete("k")
+	eventually(t, func() bool { return atomic.LoadInt32(&calls) == 3 })
+	time.Sleep(10 * time.Millisecond)
+	_, ok := m.Get("k")
+	assert.False(t, ok)
+	assert.Equal(t, 0, m.Size())
+}
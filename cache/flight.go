@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrLoadPanicked the call shared by concurrent callers of a key panicked
+var ErrLoadPanicked = errors.New("load panicked")
+
+// flightCall an in-flight or completed call of flightGroup
+type flightCall struct {
+	wg  sync.WaitGroup
+	val *Value
+	// dups the number of callers waiting for the call
+	dups int
+}
+
+// flightGroup coalesces concurrent calls with the same key into one execution
+type flightGroup struct {
+	mu sync.Mutex
+	m  map[string]*flightCall
+}
+
+// do executes f for key once at a time, callers arriving while f is running
+// wait for it and share its result. shared is true if the result came from another caller.
+// If f panics, the waiting callers get an ErrLoadPanicked error and the panic is propagated to the caller of f.
+func (g *flightGroup) do(key string, f func() *Value) (val *Value, shared bool) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*flightCall)
+	}
+	if c, ok := g.m[key]; ok {
+		c.dups++
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, true
+	}
+	c := &flightCall{}
+	c.wg.Add(1)
+	g.m[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		r := recover()
+		if r != nil {
+			c.val = &Value{Err: fmt.Errorf("%w: %v", ErrLoadPanicked, r)}
+		}
+		c.wg.Done()
+
+		g.mu.Lock()
+		delete(g.m, key)
+		g.mu.Unlock()
+
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	c.val = f()
+	return c.val, false
+}
@@ -0,0 +1,302 @@
+package cache
+
+import (
+	"math"
+	"runtime/metrics"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultGovernorThreshold    = 0.9
+	defaultGovernorInterval     = time.Second
+	defaultGovernorMinShedRatio = 0.01
+)
+
+// Shedder is a cache which can evict entries under memory pressure, implemented by LRUCache and TieredCache
+type Shedder interface {
+	// Size returns the number of entries
+	Size() int
+	// Shed evicts at most n entries, returning the number evicted
+	Shed(n int) int
+}
+
+// MemStats memory usage sampled by MemoryGovernor
+type MemStats struct {
+	// Live heap bytes
+	Live uint64
+	// Limit bytes, 0 if there is no limit
+	Limit uint64
+	// GCCycles the number of completed GC cycles. Live heap is only measured by GC, so after
+	// shedding, MemoryGovernor waits for a new cycle before shedding again. 0 if unknown,
+	// then it does not wait.
+	GCCycles uint64
+}
+
+// ShedReport reports a shedding round of MemoryGovernor
+type ShedReport struct {
+	MemStats
+	// Shed number of entries evicted of each cache
+	Shed map[string]int
+}
+
+// ReadMemStats samples runtime/metrics: live heap bytes after the last GC, GOMEMLIMIT and
+// completed GC cycles, the limit is 0 if GOMEMLIMIT is not set
+func ReadMemStats() MemStats {
+	samples := []metrics.Sample{
+		{Name: "/gc/heap/live:bytes"},
+		{Name: "/gc/gomemlimit:bytes"},
+		// fallback if live heap is not supported or not measured yet
+		{Name: "/memory/classes/heap/objects:bytes"},
+		{Name: "/gc/cycles/total:gc-cycles"},
+	}
+	metrics.Read(samples)
+
+	var stats MemStats
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		stats.Live = samples[0].Value.Uint64()
+	}
+	// live heap is 0 before the first GC
+	if stats.Live == 0 && samples[2].Value.Kind() == metrics.KindUint64 {
+		stats.Live = samples[2].Value.Uint64()
+	}
+	// no limit is math.MaxInt64
+	if samples[1].Value.Kind() == metrics.KindUint64 && samples[1].Value.Uint64() < math.MaxInt64 {
+		stats.Limit = samples[1].Value.Uint64()
+	}
+	if samples[3].Value.Kind() == metrics.KindUint64 {
+		stats.GCCycles = samples[3].Value.Uint64()
+	}
+	return stats
+}
+
+// GovernorOptions options for memory governor
+type GovernorOptions struct {
+	threshold float64
+	limit     uint64
+	interval  time.Duration
+	clock     Clock
+	memStats  func() MemStats
+	onShed    func(report ShedReport)
+}
+
+// GovernorOption ...
+type GovernorOption func(options *GovernorOptions)
+
+// NewGovernorOptions new a governor options
+func NewGovernorOptions() GovernorOptions {
+	return GovernorOptions{
+		threshold: defaultGovernorThreshold,
+		interval:  defaultGovernorInterval,
+		clock:     SystemClock{},
+		memStats:  ReadMemStats,
+	}
+}
+
+// GovernorThreshold set the fraction of the limit above which caches are shed
+func GovernorThreshold(v float64) GovernorOption {
+	return func(options *GovernorOptions) {
+		options.threshold = v
+	}
+}
+
+// GovernorLimit set the memory limit in bytes, GOMEMLIMIT is used if not set.
+// Without any limit the governor does nothing.
+func GovernorLimit(v uint64) GovernorOption {
+	return func(options *GovernorOptions) {
+		options.limit = v
+	}
+}
+
+// GovernorInterval set the sampling interval
+func GovernorInterval(d time.Duration) GovernorOption {
+	return func(options *GovernorOptions) {
+		options.interval = d
+	}
+}
+
+// GovernorClock set the clock of the sampling ticker
+func GovernorClock(c Clock) GovernorOption {
+	return func(options *GovernorOptions) {
+		options.clock = c
+	}
+}
+
+// GovernorMemStats set the function sampling memory usage, ReadMemStats by default
+func GovernorMemStats(f func() MemStats) GovernorOption {
+	return func(options *GovernorOptions) {
+		options.memStats = f
+	}
+}
+
+// OnShed set a callback called after each round evicting entries
+func OnShed(f func(report ShedReport)) GovernorOption {
+	return func(options *GovernorOptions) {
+		options.onShed = f
+	}
+}
+
+// MemoryGovernor samples memory usage periodically, when live heap is above threshold * limit
+// it evicts entries of the registered caches in proportion to their weights, a round per
+// interval until the pressure subsides.
+//
+// The number of entries evicted in a round is the fraction of live heap above the target
+// applied to the entries of all caches, assuming entries take a similar share of the heap.
+type MemoryGovernor struct {
+	mu     sync.Mutex
+	caches map[string]*governedCache
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	// shedCycles GCCycles of the last shedding round
+	shedCycles uint64
+
+	opts GovernorOptions
+}
+
+type governedCache struct {
+	cache  Shedder
+	weight float64
+}
+
+// NewMemoryGovernor new a memory governor sampling in the background until Close
+func NewMemoryGovernor(opt ...GovernorOption) *MemoryGovernor {
+	opts := NewGovernorOptions()
+	for _, o := range opt {
+		o(&opts)
+	}
+
+	g := &MemoryGovernor{
+		caches: make(map[string]*governedCache),
+		closed: make(chan struct{}),
+		opts:   opts,
+	}
+	go g.run(newHandledTicker(opts.clock, opts.interval))
+	return g
+}
+
+// Register registers a cache with weight, a cache of weight 2 sheds twice the entries of weight 1.
+// Registering an existing name replaces it.
+func (g *MemoryGovernor) Register(name string, cache Shedder, weight float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.caches[name] = &governedCache{cache: cache, weight: weight}
+}
+
+// Unregister ...
+func (g *MemoryGovernor) Unregister(name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.caches, name)
+}
+
+// Close stops sampling
+func (g *MemoryGovernor) Close() {
+	g.closeOnce.Do(func() {
+		close(g.closed)
+	})
+}
+
+func (g *MemoryGovernor) run(t Ticker) {
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C():
+			g.Check()
+			tickHandled(t)
+		case <-g.closed:
+			return
+		}
+	}
+}
+
+// Check samples memory usage once and sheds if it is above the threshold,
+// returning the number of entries evicted. It does not shed again until a GC cycle has
+// completed since the last shedding round, as live heap would still include the shed entries.
+func (g *MemoryGovernor) Check() int {
+	stats := g.opts.memStats()
+	if g.opts.limit > 0 {
+		stats.Limit = g.opts.limit
+	}
+	if stats.Limit == 0 {
+		return 0
+	}
+	if stats.GCCycles != 0 && stats.GCCycles == atomic.LoadUint64(&g.shedCycles) {
+		return 0
+	}
+
+	target := uint64(float64(stats.Limit) * g.opts.threshold)
+	if stats.Live <= target {
+		return 0
+	}
+
+	ratio := float64(stats.Live-target) / float64(stats.Live)
+	if ratio < defaultGovernorMinShedRatio {
+		ratio = defaultGovernorMinShedRatio
+	}
+
+	shed := g.shed(ratio)
+	total := 0
+	for _, n := range shed {
+		total += n
+	}
+	if total > 0 {
+		atomic.StoreUint64(&g.shedCycles, stats.GCCycles)
+	}
+	if total > 0 && g.opts.onShed != nil {
+		g.opts.onShed(ShedReport{MemStats: stats, Shed: shed})
+	}
+	return total
+}
+
+// shed evicts ratio of all entries, split between caches by weight
+func (g *MemoryGovernor) shed(ratio float64) map[string]int {
+	g.mu.Lock()
+	names := make([]string, 0, len(g.caches))
+	caches := make([]*governedCache, 0, len(g.caches))
+	for name := range g.caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		caches = append(caches, g.caches[name])
+	}
+	g.mu.Unlock()
+
+	var totalSize int
+	var totalWeight float64
+	sizes := make([]int, len(caches))
+	for i, c := range caches {
+		if c.weight <= 0 {
+			continue
+		}
+		sizes[i] = c.cache.Size()
+		totalSize += sizes[i]
+		totalWeight += c.weight
+	}
+
+	shed := make(map[string]int, len(caches))
+	if totalSize == 0 {
+		return shed
+	}
+
+	toShed := math.Ceil(ratio * float64(totalSize))
+	for i, c := range caches {
+		if c.weight <= 0 || sizes[i] == 0 {
+			continue
+		}
+		n := int(math.Ceil(toShed * c.weight / totalWeight))
+		if n > sizes[i] {
+			n = sizes[i]
+		}
+		if n = c.cache.Shed(n); n > 0 {
+			shed[names[i]] = n
+		}
+	}
+	return shed
+}
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache_Shed(t *testing.T) {
+	var evicted []string
+	cache := NewLRUCache(OnEvicted(func(key string, value *Value) {
+		evicted = append(evicted, key)
+	}))
+	for i := 0; i < 5; i++ {
+		cache.Put(strconv.Itoa(i), i)
+	}
+	cache.Get("0")
+
+	assert.Equal(t, 2, cache.Shed(2))
+	assert.Equal(t, []string{"0", "1"}, evicted)
+	assert.Equal(t, 3, cache.Size())
+
+	assert.Equal(t, 3, cache.Shed(10))
+	assert.Equal(t, 0, cache.Size())
+}
+
+func TestMemoryGovernor_Check(t *testing.T) {
+	var mu sync.Mutex
+	stats := MemStats{Live: 80, Limit: 100}
+	var reports []ShedReport
+
+	g := NewMemoryGovernor(
+		GovernorClock(NewFakeClock(time.Now())),
+		GovernorThreshold(0.5),
+		GovernorMemStats(func() MemStats {
+			mu.Lock()
+			defer mu.Unlock()
+			return stats
+		}),
+		OnShed(func(report ShedReport) {
+			reports = append(reports, report)
+		}))
+	defer g.Close()
+
+	a := NewLRUCache()
+	b := NewLRUCache()
+	for i := 0; i < 100; i++ {
+		a.Put(strconv.Itoa(i), i)
+		b.Put(strconv.Itoa(i), i)
+	}
+	g.Register("a", a, 3)
+	g.Register("b", b, 1)
+
+	// 30 of 80 live bytes above the target of 50: shed 3/8 of 200 entries, 3:1 by weight
+	assert.Equal(t, 76, g.Check())
+	assert.Equal(t, 100-57, a.Size())
+	assert.Equal(t, 100-19, b.Size())
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, map[string]int{"a": 57, "b": 19}, reports[0].Shed)
+	assert.Equal(t, stats, reports[0].MemStats)
+
+	// below the target
+	mu.Lock()
+	stats.Live = 50
+	mu.Unlock()
+	assert.Equal(t, 0, g.Check())
+	assert.Equal(t, 1, len(reports))
+
+	// no limit
+	mu.Lock()
+	stats = MemStats{Live: 1 << 40}
+	mu.Unlock()
+	assert.Equal(t, 0, g.Check())
+
+	// limit set by option, 0.55 of live bytes above the target
+	g2 := NewMemoryGovernor(
+		GovernorClock(NewFakeClock(time.Now())),
+		GovernorLimit(1<<39),
+		GovernorMemStats(func() MemStats { return MemStats{Live: 1 << 40} }))
+	defer g2.Close()
+	g2.Register("b", b, 1)
+	assert.Equal(t, 45, g2.Check())
+	assert.Equal(t, 81-45, b.Size())
+}
+
+func TestMemoryGovernor_WaitsForGC(t *testing.T) {
+	var mu sync.Mutex
+	stats := MemStats{Live: 100, Limit: 100, GCCycles: 1}
+	g := NewMemoryGovernor(
+		GovernorClock(NewFakeClock(time.Now())),
+		GovernorThreshold(0.5),
+		GovernorMemStats(func() MemStats {
+			mu.Lock()
+			defer mu.Unlock()
+			return stats
+		}))
+	defer g.Close()
+
+	cache := NewLRUCache()
+	for i := 0; i < 100; i++ {
+		cache.Put(strconv.Itoa(i), i)
+	}
+	g.Register("c", cache, 1)
+
+	assert.Equal(t, 50, g.Check())
+	// live heap is not measured again until the next GC
+	assert.Equal(t, 0, g.Check())
+	assert.Equal(t, 50, cache.Size())
+
+	mu.Lock()
+	stats.GCCycles = 2
+	mu.Unlock()
+	assert.Equal(t, 25, g.Check())
+	assert.Equal(t, 25, cache.Size())
+}
+
+func TestMemoryGovernor_Ticker(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	shed := make(chan ShedReport, 1)
+	g := NewMemoryGovernor(
+		GovernorClock(clock),
+		GovernorInterval(time.Second),
+		GovernorMemStats(func() MemStats { return MemStats{Live: 100, Limit: 100} }),
+		OnShed(func(report ShedReport) {
+			shed <- report
+		}))
+	defer g.Close()
+
+	cache := NewLRUCache()
+	cache.Put("k", 1)
+	g.Register("c", cache, 1)
+
+	clock.Advance(time.Second)
+	select {
+	case report := <-shed:
+		assert.Equal(t, 1, report.Shed["c"])
+	default:
+		t.Fatal("not shed on the tick")
+	}
+	assert.Equal(t, 0, cache.Size())
+}
+
+func TestReadMemStats(t *testing.T) {
+	runtime.GC()
+	stats := ReadMemStats()
+	assert.True(t, stats.Live > 0)
+	assert.True(t, stats.GCCycles > 0)
+}
@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultHotKeysCapacity = 128
+
+// HotKey a frequently accessed key, its real count is in [Count - Error, Count]
+type HotKey struct {
+	Key   string
+	Count uint64
+	Error uint64
+}
+
+// hotCounter counter of a tracked key
+type hotCounter struct {
+	key   string
+	count uint64
+	err   uint64
+	index int
+
+	// accesses within the current second
+	window      int64
+	windowCount int
+}
+
+// hotCounters a min-heap of counters by count
+type hotCounters []*hotCounter
+
+func (h hotCounters) Len() int           { return len(h) }
+func (h hotCounters) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h hotCounters) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *hotCounters) Push(x interface{}) {
+	c := x.(*hotCounter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *hotCounters) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// hotKeyTracker tracks the most frequently accessed keys with the space-saving algorithm:
+// at most capacity keys are counted, a new key replaces the least counted one and inherits
+// its count as the error. Any key accessed more than total/capacity times is tracked.
+type hotKeyTracker struct {
+	mu       sync.Mutex
+	capacity int
+	counters map[string]*hotCounter
+	heap     hotCounters
+
+	clock Clock
+	qps   int
+	onHot func(key string, qps int)
+}
+
+func newHotKeyTracker(capacity int, qps int, onHot func(key string, qps int), clock Clock) *hotKeyTracker {
+	if capacity <= 0 {
+		capacity = defaultHotKeysCapacity
+	}
+	return &hotKeyTracker{
+		capacity: capacity,
+		counters: make(map[string]*hotCounter, capacity),
+		heap:     make(hotCounters, 0, capacity),
+		clock:    clock,
+		qps:      qps,
+		onHot:    onHot,
+	}
+}
+
+// touch counts an access of key, calling onHot when the accesses of key within a second reach qps
+func (t *hotKeyTracker) touch(key string) {
+	hot := false
+
+	t.mu.Lock()
+	c, ok := t.counters[key]
+	if ok {
+		c.count++
+		heap.Fix(&t.heap, c.index)
+	} else if len(t.heap) < t.capacity {
+		c = &hotCounter{key: key, count: 1}
+		t.counters[key] = c
+		heap.Push(&t.heap, c)
+	} else {
+		// replace the least counted key
+		c = t.heap[0]
+		delete(t.counters, c.key)
+		c.key = key
+		c.err = c.count
+		c.count++
+		c.window, c.windowCount = 0, 0
+		t.counters[key] = c
+		heap.Fix(&t.heap, 0)
+	}
+
+	if t.onHot != nil {
+		window := t.clock.Now().UnixNano() / int64(time.Second)
+		if c.window != window {
+			c.window, c.windowCount = window, 0
+		}
+		c.windowCount++
+		hot = c.windowCount == t.qps
+	}
+	t.mu.Unlock()
+
+	if hot {
+		t.onHot(key, t.qps)
+	}
+}
+
+// top returns at most k keys with the largest counts
+func (t *hotKeyTracker) top(k int) []HotKey {
+	t.mu.Lock()
+	keys := make([]HotKey, 0, len(t.heap))
+	for _, c := range t.heap {
+		keys = append(keys, HotKey{Key: c.key, Count: c.count, Error: c.err})
+	}
+	t.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if k < len(keys) {
+		keys = keys[:k]
+	}
+	return keys
+}
+
+// HotKeys returns at most k most frequently accessed keys by Get and Load with estimated counts,
+// nil if hot keys are not tracked
+func (cache *LRUCache) HotKeys(k int) []HotKey {
+	if cache.hotKeys == nil || k <= 0 {
+		return nil
+	}
+	return cache.hotKeys.top(k)
+}
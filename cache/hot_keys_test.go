@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotKeyTracker_SpaceSaving(t *testing.T) {
+	tracker := newHotKeyTracker(4, 0, nil, SystemClock{})
+
+	// a key with more than 1/4 of all accesses is always tracked
+	for i := 0; i < 1000; i++ {
+		tracker.touch("hot")
+		tracker.touch("warm:" + strconv.Itoa(i%3))
+		tracker.touch("cold:" + strconv.Itoa(i))
+	}
+
+	top := tracker.top(2)
+	assert.Len(t, top, 2)
+	assert.Equal(t, "hot", top[0].Key)
+	assert.True(t, top[0].Count >= 1000)
+	assert.True(t, top[0].Count-top[0].Error <= 1000)
+
+	var total uint64
+	for _, k := range tracker.top(10) {
+		total += k.Count
+	}
+	// counts of space-saving sum up to all accesses
+	assert.Equal(t, uint64(3000), total)
+}
+
+func TestLRUCache_HotKeys(t *testing.T) {
+	cache := NewLRUCache()
+	assert.Nil(t, cache.HotKeys(10))
+
+	cache = NewLRUCache(TrackHotKeys(16))
+	cache.Put("a", 1)
+	for i := 0; i < 3; i++ {
+		cache.Get("a")
+	}
+	cache.Get("b")
+	cache.Load("b", WithLoader(func(key string) *Value {
+		return &Value{Val: key}
+	}))
+
+	assert.Equal(t, []HotKey{{Key: "a", Count: 3}, {Key: "b", Count: 2}}, cache.HotKeys(10))
+	assert.Equal(t, []HotKey{{Key: "a", Count: 3}}, cache.HotKeys(1))
+
+	var hot []HotKey
+	h := AdminHandler(map[string]Cache{"c": cache})
+	assert.Equal(t, http.StatusOK, doAdmin(t, h, http.MethodGet, "/c/hot?limit=1", &hot))
+	assert.Equal(t, []HotKey{{Key: "a", Count: 3}}, hot)
+}
+
+func TestLRUCache_OnHotKey(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	var hot []string
+	cache := NewLRUCache(WithClock(clock), OnHotKey(3, func(key string, qps int) {
+		assert.Equal(t, 3, qps)
+		hot = append(hot, key)
+	}))
+
+	for i := 0; i < 5; i++ {
+		cache.Get("a")
+	}
+	cache.Get("b")
+	assert.Equal(t, []string{"a"}, hot)
+
+	// a new second
+	clock.Advance(time.Second)
+	cache.Get("a")
+	cache.Get("a")
+	clock.Advance(time.Second)
+	cache.Get("a")
+	assert.Equal(t, []string{"a"}, hot)
+	cache.Get("a")
+	cache.Get("a")
+	assert.Equal(t, []string{"a", "a"}, hot)
+}
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"time"
+)
+
+// Operation an operation of a cache seen by interceptors
+type Operation string
+
+const (
+	OpGet    Operation = "Get"
+	OpPut    Operation = "Put"
+	OpDelete Operation = "Delete"
+	OpLoad   Operation = "Load"
+	// OpLoader a call of the loader, including retries, may run under the cache lock
+	OpLoader Operation = "Loader"
+	// OpEvict an entry evicted because the cache is full or shed, Before and After are called at once
+	OpEvict Operation = "Evict"
+)
+
+// OpInfo an intercepted operation
+type OpInfo struct {
+	Op       Operation
+	Key      string
+	Duration time.Duration
+
+	// Hit is true if the key was in the cache for Get and Load, replaced for Put
+	Hit bool
+
+	// Err error of the value got or loaded
+	Err error
+}
+
+// Interceptor observes operations of a cache, e.g. for tracing, logging or metrics.
+// Interceptors are called synchronously and should be fast. They are called without holding
+// the cache lock, except OpLoader of an LRUCache Load loading a missing element, or an expired
+// one with SyncLoad, which runs under the write lock like the loader itself, so its
+// interceptors must not call back into the cache.
+type Interceptor interface {
+	// Before is called before an operation, the returned state is passed to After
+	Before(op Operation, key string) interface{}
+	// After is called after the operation
+	After(state interface{}, info OpInfo)
+}
+
+// WithInterceptors add interceptors of the cache, called in order by Before and in reverse order by After
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(options *Options) {
+		options.interceptors = append(options.interceptors, interceptors...)
+	}
+}
+
+// interceptCall an intercepted operation in progress
+type interceptCall struct {
+	interceptors []Interceptor
+	states       []interface{}
+	clock        Clock
+	start        time.Time
+	op           Operation
+	key          string
+}
+
+// intercept calls Before of interceptors, returns nil if there is no interceptor
+func intercept(interceptors []Interceptor, clock Clock, op Operation, key string) *interceptCall {
+	if len(interceptors) == 0 {
+		return nil
+	}
+
+	call := &interceptCall{
+		interceptors: interceptors,
+		states:       make([]interface{}, len(interceptors)),
+		clock:        clock,
+		op:           op,
+		key:          key,
+	}
+	for i, interceptor := range interceptors {
+		call.states[i] = interceptor.Before(op, key)
+	}
+	call.start = clock.Now()
+	return call
+}
+
+// done calls After of interceptors
+func (call *interceptCall) done(hit bool, err error) {
+	info := OpInfo{
+		Op:       call.op,
+		Key:      call.key,
+		Duration: call.clock.Now().Sub(call.start),
+		Hit:      hit,
+		Err:      err,
+	}
+	for i := len(call.interceptors) - 1; i >= 0; i-- {
+		call.interceptors[i].After(call.states[i], info)
+	}
+}
+
+func valueErr(v *Value) error {
+	if v == nil {
+		return nil
+	}
+	return v.Err
+}
+
+// Span the subset of a tracing span used by TracingInterceptor,
+// a thin wrapper maps it onto the span of a tracing library such as OpenTelemetry
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts spans for TracingInterceptor
+type Tracer interface {
+	Start(name string) Span
+}
+
+// TracingInterceptor returns an interceptor starting a span named "cache.{Op}" for each operation,
+// with attributes cache.name, cache.key and cache.hit
+func TracingInterceptor(name string, tracer Tracer) Interceptor {
+	return tracingInterceptor{name: name, tracer: tracer}
+}
+
+type tracingInterceptor struct {
+	name   string
+	tracer Tracer
+}
+
+func (t tracingInterceptor) Before(op Operation, key string) interface{} {
+	span := t.tracer.Start("cache." + string(op))
+	span.SetAttribute("cache.name", t.name)
+	span.SetAttribute("cache.key", key)
+	return span
+}
+
+func (t tracingInterceptor) After(state interface{}, info OpInfo) {
+	span := state.(Span)
+	span.SetAttribute("cache.hit", info.Hit)
+	if info.Err != nil {
+		span.RecordError(info.Err)
+	}
+	span.End()
+}
+
+// LogInterceptor returns an interceptor calling log after each operation, e.g. to write structured logs
+func LogInterceptor(log func(info OpInfo)) Interceptor {
+	return logInterceptor(log)
+}
+
+type logInterceptor func(info OpInfo)
+
+func (l logInterceptor) Before(op Operation, key string) interface{} {
+	return nil
+}
+
+func (l logInterceptor) After(state interface{}, info OpInfo) {
+	l(info)
+}
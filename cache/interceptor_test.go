@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordInterceptor struct {
+	name   string
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordInterceptor) Before(op Operation, key string) interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, fmt.Sprintf("%s before %s %s", r.name, op, key))
+	return len(r.events)
+}
+
+func (r *recordInterceptor) After(state interface{}, info OpInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, fmt.Sprintf("%s after %s %s %v %v %v %d", r.name, info.Op, info.Key, info.Hit, info.Err, info.Duration, state))
+}
+
+func TestLRUCache_Interceptors(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	first := &recordInterceptor{name: "first"}
+	second := &recordInterceptor{name: "second"}
+	cache := NewLRUCache(WithClock(clock), WithInterceptors(first, second))
+
+	cache.Put("a", 1)
+	cache.Put("a", 2)
+	cache.Get("a")
+	cache.Get("b")
+	cache.Load("b", WithLoader(func(key string) *Value {
+		clock.Advance(time.Second)
+		return &Value{Err: errOrigin}
+	}))
+	cache.Delete("b")
+	cache.Put("c", 3)
+	cache.Shed(1)
+
+	assert.Equal(t, []string{
+		"first before Put a", "first after Put a false <nil> 0s 1",
+		"first before Put a", "first after Put a true <nil> 0s 3",
+		"first before Get a", "first after Get a true <nil> 0s 5",
+		"first before Get b", "first after Get b false <nil> 0s 7",
+		"first before Load b",
+		"first before Loader b", "first after Loader b false origin down 1s 10",
+		"first after Load b false origin down 1s 9",
+		"first before Delete b", "first after Delete b false <nil> 0s 13",
+		"first before Put c", "first after Put c false <nil> 0s 15",
+		"first before Evict a", "first after Evict a false <nil> 0s 17",
+	}, first.events)
+
+	// second sees the same operations
+	assert.Equal(t, len(first.events), len(second.events))
+}
+
+func TestInterceptorOrder(t *testing.T) {
+	var events []string
+	record := func(name string) Interceptor {
+		return &funcInterceptor{
+			before: func() { events = append(events, name+" before") },
+			after:  func() { events = append(events, name+" after") },
+		}
+	}
+	cache := NewLRUCache(WithInterceptors(record("a"), record("b")))
+	cache.Get("k")
+	assert.Equal(t, []string{"a before", "b before", "b after", "a after"}, events)
+}
+
+type funcInterceptor struct {
+	before, after func()
+}
+
+func (f *funcInterceptor) Before(op Operation, key string) interface{} {
+	f.before()
+	return nil
+}
+
+func (f *funcInterceptor) After(state interface{}, info OpInfo) {
+	f.after()
+}
+
+type beforeInterceptor func(op Operation, key string)
+
+func (f beforeInterceptor) Before(op Operation, key string) interface{} {
+	f(op, key)
+	return nil
+}
+
+func (f beforeInterceptor) After(state interface{}, info OpInfo) {}
+
+func TestLRUCache_LoaderInterceptorUnderLock(t *testing.T) {
+	var cache *LRUCache
+	// events of the loader and of a Size call started by the OpLoader interceptor
+	events := make(chan string, 2)
+	cache = NewLRUCache(WithClock(NewFakeClock(time.Now())), WithInterceptors(beforeInterceptor(func(op Operation, key string) {
+		switch op {
+		case OpLoad:
+			// not under the lock, the cache can be used
+			cache.Size()
+		case OpLoader:
+			go func() {
+				cache.Size()
+				events <- "size"
+			}()
+		}
+	})))
+
+	loader := WithLoader(func(key string) *Value {
+		events <- "loader"
+		return &Value{Val: key}
+	})
+	expect := func() {
+		// Size waits for the lock held while loading
+		assert.Equal(t, "loader", <-events)
+		assert.Equal(t, "size", <-events)
+	}
+
+	// a missing element
+	cache.Load("a", loader, ExpirationOption(time.Minute))
+	expect()
+
+	// an expired element with sync load
+	cache.Put("b", 1)
+	cache.Load("b", loader, SyncLoad(true))
+	expect()
+}
+
+type testSpan struct {
+	name  string
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) { s.attrs[key] = value }
+func (s *testSpan) RecordError(err error)                      { s.err = err }
+func (s *testSpan) End()                                       { s.ended = true }
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(name string) Span {
+	span := &testSpan{name: name, attrs: make(map[string]interface{})}
+	t.spans = append(t.spans, span)
+	return span
+}
+
+func TestTracingInterceptor(t *testing.T) {
+	tracer := &testTracer{}
+	var logs []OpInfo
+	cache := NewLRUCache(WithInterceptors(
+		TracingInterceptor("users", tracer),
+		LogInterceptor(func(info OpInfo) { logs = append(logs, info) })))
+
+	cache.Put("k", 1)
+	cache.Load("x", WithLoader(func(key string) *Value {
+		return &Value{Err: errOrigin}
+	}))
+
+	assert.Len(t, tracer.spans, 3)
+	assert.Equal(t, "cache.Put", tracer.spans[0].name)
+	assert.Equal(t, map[string]interface{}{"cache.name": "users", "cache.key": "k", "cache.hit": false}, tracer.spans[0].attrs)
+	assert.True(t, tracer.spans[0].ended)
+	assert.Equal(t, "cache.Load", tracer.spans[1].name)
+	assert.Equal(t, "cache.Loader", tracer.spans[2].name)
+	assert.Equal(t, errOrigin, tracer.spans[1].err)
+	assert.True(t, tracer.spans[1].ended)
+
+	assert.Len(t, logs, 3)
+	assert.Equal(t, OpLoader, logs[1].Op)
+	assert.Equal(t, OpLoad, logs[2].Op)
+}
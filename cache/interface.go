@@ -8,6 +8,9 @@ import (
 var (
 	// ErrLoadTimeout load timeout error
 	ErrLoadTimeout = errors.New("load timeout")
+
+	// ErrCircuitOpen the loader is not called because the circuit breaker of the cache is open
+	ErrCircuitOpen = errors.New("circuit open")
 )
 
 // Loader loader function
@@ -40,6 +43,8 @@ type Cache interface {
 
 	// CompareAndSwap adds an element to the cache if the existing entry matches the old innerValue.
 	// It returns the element in cache after function is executed and true if the element was replaced, false otherwise.
+	// old is the *Value got from the cache, or nil to match a missing element; if not replaced,
+	// the existing *Value is returned, nil if it is missing.
 	CompareAndSwap(key string, old, new interface{}, opts ...EntryOption) (interface{}, bool)
 
 	// Load innerValue by call loader function.
@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+)
+
+// InvalidationOp operation of an invalidation
+type InvalidationOp uint8
+
+const (
+	// InvalidateKey the key was deleted
+	InvalidateKey InvalidationOp = iota + 1
+	// InvalidatePrefix all keys with the prefix were deleted
+	InvalidatePrefix
+	// InvalidatePut the key was written, copies of other caches are stale
+	InvalidatePut
+)
+
+// Invalidation a message telling caches to drop their copies of a key or prefix
+type Invalidation struct {
+	// Cache name of the cache, caches with the same name on a bus are replicas
+	Cache string
+	// Origin id of the cache instance publishing the invalidation
+	Origin string
+	// Seq sequence number of the invalidation in Origin, increasing by one for each message
+	Seq uint64
+
+	Op  InvalidationOp
+	Key string
+}
+
+// InvalidationBus delivers invalidations to all subscribers, including those of the
+// publisher's process. Invalidations published by one goroutine must be delivered to
+// each subscriber in order.
+type InvalidationBus interface {
+	// Publish an invalidation to all subscribers
+	Publish(msg Invalidation) error
+	// Subscribe registers f to be called with each invalidation,
+	// the returned function unregisters it.
+	Subscribe(f func(msg Invalidation)) (unsubscribe func())
+}
+
+// subscribers a set of invalidation subscribers
+type subscribers struct {
+	mu     sync.RWMutex
+	nextID uint64
+	fs     map[uint64]func(msg Invalidation)
+}
+
+func (s *subscribers) subscribe(f func(msg Invalidation)) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fs == nil {
+		s.fs = make(map[uint64]func(msg Invalidation))
+	}
+	id := s.nextID
+	s.nextID++
+	s.fs[id] = f
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(s.fs, id)
+	}
+}
+
+func (s *subscribers) deliver(msg Invalidation) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, f := range s.fs {
+		f(msg)
+	}
+}
+
+// LocalBus an in-process InvalidationBus, invalidations are delivered synchronously
+type LocalBus struct {
+	subs subscribers
+}
+
+// NewLocalBus new a local bus
+func NewLocalBus() *LocalBus {
+	return &LocalBus{}
+}
+
+// Publish ...
+func (b *LocalBus) Publish(msg Invalidation) error {
+	b.subs.deliver(msg)
+	return nil
+}
+
+// Subscribe ...
+func (b *LocalBus) Subscribe(f func(msg Invalidation)) func() {
+	return b.subs.subscribe(f)
+}
+
+// invalidator publishes invalidations of a cache and applies those of its replicas.
+//
+// Each cache instance has a random origin id, its own invalidations are suppressed.
+// Invalidations of an origin are published in sequence order, so a replica drops any
+// invalidation not newer than the last one applied from the same origin, which
+// removes duplicates and keeps invalidations of a key in order.
+type invalidator struct {
+	name   string
+	origin string
+	bus    InvalidationBus
+
+	// pubLock keeps Seq order equal to publish order
+	pubLock sync.Mutex
+	seq     uint64
+
+	applyLock sync.Mutex
+	lastSeq   map[string]uint64
+
+	unsubscribe func()
+}
+
+func newInvalidator(name string, bus InvalidationBus, apply func(msg Invalidation)) *invalidator {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+
+	inv := &invalidator{
+		name:    name,
+		origin:  hex.EncodeToString(id),
+		bus:     bus,
+		lastSeq: make(map[string]uint64),
+	}
+	inv.unsubscribe = bus.Subscribe(func(msg Invalidation) {
+		if inv.accept(msg) {
+			apply(msg)
+		}
+	})
+
+	return inv
+}
+
+func (inv *invalidator) publish(op InvalidationOp, key string) {
+	inv.pubLock.Lock()
+	defer inv.pubLock.Unlock()
+
+	inv.seq++
+	_ = inv.bus.Publish(Invalidation{
+		Cache:  inv.name,
+		Origin: inv.origin,
+		Seq:    inv.seq,
+		Op:     op,
+		Key:    key,
+	})
+}
+
+func (inv *invalidator) accept(msg Invalidation) bool {
+	if msg.Cache != inv.name || msg.Origin == inv.origin {
+		return false
+	}
+
+	inv.applyLock.Lock()
+	defer inv.applyLock.Unlock()
+
+	if msg.Seq <= inv.lastSeq[msg.Origin] {
+		return false
+	}
+	inv.lastSeq[msg.Origin] = msg.Seq
+	return true
+}
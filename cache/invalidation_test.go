@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalBus_Invalidation(t *testing.T) {
+	bus := NewLocalBus()
+	a := NewLRUCache(WithInvalidation("users", bus))
+	b := NewLRUCache(WithInvalidation("users", bus))
+	other := NewLRUCache(WithInvalidation("orders", bus))
+
+	for _, c := range []*LRUCache{a, b, other} {
+		c.Put("k1", 1)
+		c.Put("k2", 2)
+		c.Put("p:1", 3)
+		c.Put("p:2", 4)
+	}
+
+	a.Delete("k1")
+	_, ok := b.Get("k1")
+	assert.False(t, ok)
+
+	// origin keeps its own put
+	a.Put("k2", 22)
+	v, ok := a.Get("k2")
+	assert.True(t, ok)
+	assert.Equal(t, 22, v.Val)
+	_, ok = b.Get("k2")
+	assert.False(t, ok)
+
+	b.DeleteByPrefix("p:")
+	assert.Equal(t, 1, a.Size())
+
+	// caches with another name are not invalidated
+	assert.Equal(t, 4, other.Size())
+}
+
+func TestInvalidator_DropDuplicates(t *testing.T) {
+	bus := NewLocalBus()
+	var applied []Invalidation
+	inv := newInvalidator("c", bus, func(msg Invalidation) {
+		applied = append(applied, msg)
+	})
+
+	msgs := []Invalidation{
+		{Cache: "c", Origin: "o1", Seq: 1, Op: InvalidateKey, Key: "a"},
+		{Cache: "c", Origin: "o1", Seq: 1, Op: InvalidateKey, Key: "a"},
+		{Cache: "c", Origin: "o2", Seq: 1, Op: InvalidateKey, Key: "b"},
+		{Cache: "c", Origin: "o1", Seq: 3, Op: InvalidateKey, Key: "c"},
+		{Cache: "c", Origin: "o1", Seq: 2, Op: InvalidateKey, Key: "d"},
+		{Cache: "c", Origin: inv.origin, Seq: 10, Op: InvalidateKey, Key: "e"},
+	}
+	for _, msg := range msgs {
+		_ = bus.Publish(msg)
+	}
+
+	assert.Equal(t, []Invalidation{msgs[0], msgs[2], msgs[3]}, applied)
+}
+
+func TestPeerBus_Invalidation(t *testing.T) {
+	busA, err := NewPeerBus("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer busA.Close()
+	busB, err := NewPeerBus("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer busB.Close()
+
+	busA.SetPeers(busB.Addr().String())
+	busB.SetPeers(busA.Addr().String())
+
+	a := NewLRUCache(WithInvalidation("users", busA))
+	b := NewLRUCache(WithInvalidation("users", busB))
+
+	for i := 0; i < 100; i++ {
+		b.Put("k", i)
+		a.Put("k", i)
+		a.Delete("k")
+	}
+	b.Put("last", 1)
+	a.Put("last", 2)
+
+	eventually(t, func() bool {
+		_, ok := b.Get("last")
+		return !ok
+	})
+	_, ok := b.Get("k")
+	assert.False(t, ok)
+
+	b.Delete("last")
+	eventually(t, func() bool {
+		_, ok := a.Get("last")
+		return !ok
+	})
+}
+
+// eventually waits until cond is true, failing t after 5 seconds
+func eventually(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not satisfied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"strings"
+
+	rbt "github.com/emirpasic/gods/trees/redblacktree"
+)
+
+// keyIndex ordered index of cache keys, keys sharing a prefix are adjacent
+// so prefix lookups only visit matching keys.
+//
+// It is concurrency unsafe, callers must hold the owner's lock.
+type keyIndex struct {
+	tree *rbt.Tree
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{tree: rbt.NewWithStringComparator()}
+}
+
+func (idx *keyIndex) insert(key string) {
+	idx.tree.Put(key, nil)
+}
+
+func (idx *keyIndex) remove(key string) {
+	idx.tree.Remove(key)
+}
+
+func (idx *keyIndex) clear() {
+	idx.tree.Clear()
+}
+
+// rangePrefix calls f sequentially in key order for each key starting with prefix.
+// If f returns false, range stops the iteration.
+func (idx *keyIndex) rangePrefix(prefix string, f func(key string) bool) {
+	n, _ := idx.tree.Ceiling(prefix)
+	for n != nil {
+		key := n.Key.(string)
+		if !strings.HasPrefix(key, prefix) {
+			return
+		}
+		if !f(key) {
+			return
+		}
+		n = successor(n)
+	}
+}
+
+// successor returns the next node of n in key order, nil if n is the last one
+func successor(n *rbt.Node) *rbt.Node {
+	if n.Right != nil {
+		n = n.Right
+		for n.Left != nil {
+			n = n.Left
+		}
+		return n
+	}
+
+	p := n.Parent
+	for p != nil && n == p.Right {
+		n = p
+		p = p.Parent
+	}
+	return p
+}
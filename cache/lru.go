@@ -2,7 +2,6 @@ package cache
 
 import (
 	"container/list"
-	"context"
 	"fmt"
 	"reflect"
 	"runtime"
@@ -15,6 +14,7 @@ import (
 type LRUCache struct {
 	lock   sync.RWMutex
 	values map[string]*list.Element
+	keys   *keyIndex
 
 	lruLock sync.Mutex
 	lruList *list.List
@@ -22,6 +22,15 @@ type LRUCache struct {
 	cleanFullChan      chan struct{}
 	cleanFullThreshold int
 
+	// invalidator is nil if invalidation bus is not set
+	invalidator *invalidator
+
+	// breaker is nil if circuit breaker is not set
+	breaker *circuitBreaker
+
+	// hotKeys is nil if hot keys are not tracked
+	hotKeys *hotKeyTracker
+
 	opts Options
 }
 
@@ -34,6 +43,7 @@ func NewLRUCache(opt ...Option) *LRUCache {
 	cache := &LRUCache{
 		lock:   sync.RWMutex{},
 		values: make(map[string]*list.Element, opts.maxSize),
+		keys:   newKeyIndex(),
 
 		lruLock: sync.Mutex{},
 		lruList: (&list.List{}).Init(),
@@ -44,7 +54,24 @@ func NewLRUCache(opt ...Option) *LRUCache {
 		opts: opts,
 	}
 
-	go cache.asyncClean()
+	if opts.invalidationBus != nil {
+		cache.invalidator = newInvalidator(opts.invalidationName, opts.invalidationBus, cache.applyInvalidation)
+	}
+
+	if opts.failureThreshold > 0 {
+		cache.breaker = newCircuitBreaker(opts.failureThreshold, opts.openTimeout, opts.clock)
+	}
+
+	if opts.hotKeysCapacity > 0 || opts.onHotKey != nil {
+		cache.hotKeys = newHotKeyTracker(opts.hotKeysCapacity, opts.hotKeyQPS, opts.onHotKey, opts.clock)
+	}
+
+	cleanDuration := defaultCleanDuration
+	if cache.opts.cleanDuration > cleanDuration {
+		cleanDuration = cache.opts.cleanDuration
+	}
+	// created before returning, so the first clean is due cleanDuration after NewLRUCache
+	go cache.asyncClean(newHandledTicker(opts.clock, cleanDuration))
 
 	return cache
 }
@@ -64,28 +91,33 @@ func (cache *LRUCache) lruRemove(e *list.Element) {
 	cache.lruList.Remove(e)
 }
 
-func (cache *LRUCache) asyncClean() {
-	cleanDuration := defaultCleanDuration
-	if cache.opts.cleanDuration > cleanDuration {
-		cleanDuration = cache.opts.cleanDuration
-	}
-
-	t := time.NewTicker(cleanDuration)
+func (cache *LRUCache) asyncClean(t Ticker) {
 	for {
 		select {
-		case <-t.C:
+		case <-t.C():
 			cache.cleanExpired()
+			tickHandled(t)
 		case <-cache.cleanFullChan:
 			cache.lock.Lock()
-			cache.cleanFull()
+			evicted := cache.cleanFull()
 			cache.lock.Unlock()
+			cache.notifyEvicted(evicted)
 		}
 	}
 }
 
+func (cache *LRUCache) addItem(cacheEntry *internalEntry) *list.Element {
+	e := cache.lruInsert(cacheEntry)
+	cache.values[cacheEntry.key] = e
+	cache.keys.insert(cacheEntry.key)
+	return e
+}
+
 func (cache *LRUCache) deleteItem(e *list.Element) {
-	cache.lruList.Remove(e)
-	delete(cache.values, e.Value.(*internalEntry).key)
+	key := e.Value.(*internalEntry).key
+	cache.lruRemove(e)
+	delete(cache.values, key)
+	cache.keys.remove(key)
 }
 
 func (cache *LRUCache) cleanExpired() {
@@ -94,44 +126,118 @@ func (cache *LRUCache) cleanExpired() {
 	}
 	cache.lock.Lock()
 	defer cache.lock.Unlock()
+	now := cache.opts.clock.Now().UnixNano()
 	back := cache.lruList.Back()
 	for i := 0; i < cache.opts.cleanSize && back != nil; i++ {
-		tmp := back.Value
-		if tmp.(*internalEntry).expiration <= time.Now().UnixNano() {
+		// get prev before delete, deleted element has no prev
+		prev := back.Prev()
+		if back.Value.(*internalEntry).expiration <= now {
 			cache.deleteItem(back)
 		}
-		back = back.Prev()
+		back = prev
 	}
 }
 
-func (cache *LRUCache) cleanFull() {
-	for i := 0; i < cache.opts.cleanSize && cache.lruList.Len() > 0; i++ {
+// cleanFull removes the least recently used entries,
+// the removed entries are returned only if OnEvicted or interceptors are set.
+func (cache *LRUCache) cleanFull() []*internalEntry {
+	_, evicted := cache.evictLocked(cache.opts.cleanSize)
+	return evicted
+}
+
+// evictLocked removes at most n least recently used entries, returning the number removed,
+// the removed entries are returned only if OnEvicted or interceptors are set.
+func (cache *LRUCache) evictLocked(n int) (int, []*internalEntry) {
+	var evicted []*internalEntry
+	track := cache.opts.onEvicted != nil || len(cache.opts.interceptors) > 0
+	i := 0
+	for ; i < n && cache.lruList.Len() > 0; i++ {
 		e := cache.lruList.Back()
-		cache.lruList.Remove(e)
-		delete(cache.values, e.Value.(*internalEntry).key)
+		cache.deleteItem(e)
+		if track {
+			evicted = append(evicted, e.Value.(*internalEntry))
+		}
 	}
+	return i, evicted
 }
 
-func (cache *LRUCache) callLoader(key string, eOpts EntryOptions) *internalEntry {
-	loadCtx, loadCancel := context.WithTimeout(context.Background(), eOpts.loadTimeout)
-	defer loadCancel()
-
-	retChan := make(chan *Value)
-	go func() {
-		ret := eOpts.loader(key)
-		retChan <- ret
+// Shed evicts at most n least recently used entries, returning the number evicted.
+// Evicted entries are passed to OnEvicted.
+func (cache *LRUCache) Shed(n int) int {
+	var evicted []*internalEntry
+	defer func() {
+		cache.notifyEvicted(evicted)
 	}()
 
-	var ret *Value
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	var count int
+	count, evicted = cache.evictLocked(n)
+	return count
+}
+
+// checkFull cleans the cache before inserting a new key if it is full.
+// It must be called with the cache lock held.
+func (cache *LRUCache) checkFull() []*internalEntry {
+	if cache.lruList.Len() < cache.cleanFullThreshold {
+		return nil
+	}
+
+	var evicted []*internalEntry
+	// attention: if size larger or equal maxsize, sync clean full!
+	if cache.lruList.Len() >= cache.opts.maxSize {
+		evicted = cache.cleanFull()
+	}
+
 	select {
-	case <-loadCtx.Done():
-		ret = &Value{}
-		ret.Err = fmt.Errorf(
-			"function: %s, %w",
-			runtime.FuncForPC(reflect.ValueOf(eOpts.loader).Pointer()).Name(),
-			ErrLoadTimeout)
-	case ret = <-retChan:
-		// block until loader function return within loadTimeout
+	case cache.cleanFullChan <- struct{}{}:
+		//do nothing
+	default:
+		// do nothing
+	}
+
+	return evicted
+}
+
+// notifyEvicted calls OnEvicted callback and interceptors, must be called without holding the cache lock.
+func (cache *LRUCache) notifyEvicted(evicted []*internalEntry) {
+	for _, entry := range evicted {
+		if call := cache.intercept(OpEvict, entry.key); call != nil {
+			call.done(false, nil)
+		}
+		if cache.opts.onEvicted != nil {
+			cache.opts.onEvicted(entry.key, entry.innerValue)
+		}
+	}
+}
+
+// intercept calls Before of interceptors, returns nil if there is no interceptor
+func (cache *LRUCache) intercept(op Operation, key string) *interceptCall {
+	return intercept(cache.opts.interceptors, cache.opts.clock, op, key)
+}
+
+// callLoader calls the loader of the cache, see callLoader
+func (cache *LRUCache) callLoader(key string, eOpts EntryOptions) *internalEntry {
+	call := cache.intercept(OpLoader, key)
+	entry := callLoader(key, eOpts, cache.opts.clock, cache.breaker)
+	if call != nil {
+		call.done(false, entry.innerValue.Err)
+	}
+	return entry
+}
+
+// callLoader calls the loader of eOpts with its retry policy through breaker if not nil,
+// giving up after eOpts.loadTimeout of clock
+func callLoader(key string, eOpts EntryOptions, clock Clock, breaker *circuitBreaker) *internalEntry {
+	var ret *Value
+	if breaker == nil {
+		ret = loadWithTimeout(key, eOpts, clock)
+	} else if allowed, probe := breaker.allow(); allowed {
+		ret = loadWithTimeout(key, eOpts, clock)
+		breaker.done(probe, ret.Err == nil)
+	} else {
+		ret = &Value{Err: ErrCircuitOpen}
 	}
 
 	cacheEntry := &internalEntry{
@@ -141,15 +247,44 @@ func (cache *LRUCache) callLoader(key string, eOpts EntryOptions) *internalEntry
 
 	if ret.Err != nil {
 		// do this to protect f() when f() return err in high concurrent query
-		cacheEntry.expiration = time.Now().Add(500 * time.Millisecond).UnixNano()
+		cacheEntry.expiration = clock.Now().Add(500 * time.Millisecond).UnixNano()
 	} else {
-		cacheEntry.expiration = time.Now().Add(eOpts.expireAfterWrite).UnixNano()
+		cacheEntry.expiration = clock.Now().Add(eOpts.expireAfterWrite).UnixNano()
 	}
 	cacheEntry.innerValue = ret
 
 	return cacheEntry
 }
 
+func loadWithTimeout(key string, eOpts EntryOptions, clock Clock) *Value {
+	timeout := make(chan struct{})
+	timer := clock.AfterFunc(eOpts.loadTimeout, func() {
+		close(timeout)
+	})
+	defer timer.Stop()
+
+	// buffered, so the loader goroutine does not leak after timeout
+	retChan := make(chan *Value, 1)
+	go func() {
+		ret := eOpts.retry.call(key, eOpts.loader, clock, timeout)
+		retChan <- ret
+	}()
+
+	var ret *Value
+	select {
+	case <-timeout:
+		ret = &Value{}
+		ret.Err = fmt.Errorf(
+			"function: %s, %w",
+			runtime.FuncForPC(reflect.ValueOf(eOpts.loader).Pointer()).Name(),
+			ErrLoadTimeout)
+	case ret = <-retChan:
+		// block until loader function return within loadTimeout
+	}
+
+	return ret
+}
+
 func (cache *LRUCache) asyncRefreshItem(cacheKey string, eOpts EntryOptions) {
 	// call loader
 	item := cache.callLoader(cacheKey, eOpts)
@@ -158,18 +293,31 @@ func (cache *LRUCache) asyncRefreshItem(cacheKey string, eOpts EntryOptions) {
 	defer cache.lock.Unlock()
 
 	v, ok := cache.values[cacheKey]
+	// keep the stale value, refresh again on next load
+	if ok && eOpts.staleOnCircuitOpen && item.innerValue.Err == ErrCircuitOpen {
+		v.Value.(*internalEntry).refreshed.Store(false)
+		return
+	}
 	// the key may be cleaned by the asyncClean goroutine.
 	// if cleaned, should be inserted to cache again, otherwise just re-assign.
 	if ok {
 		v.Value = item
 		cache.lruMoveToFront(v)
 	} else {
-		e := cache.lruInsert(item)
-		cache.values[cacheKey] = e
+		cache.addItem(item)
 	}
 }
 
 func (cache *LRUCache) Load(cacheKey string, opts ...EntryOption) (*Value, bool) {
+	if call := cache.intercept(OpLoad, cacheKey); call != nil {
+		v, ok := cache.load(cacheKey, opts...)
+		call.done(ok, valueErr(v))
+		return v, ok
+	}
+	return cache.load(cacheKey, opts...)
+}
+
+func (cache *LRUCache) load(cacheKey string, opts ...EntryOption) (*Value, bool) {
 	eOpts := cache.opts.defaultEntryOpts
 	for _, o := range opts {
 		o(&eOpts)
@@ -177,7 +325,11 @@ func (cache *LRUCache) Load(cacheKey string, opts ...EntryOption) (*Value, bool)
 
 	// loader function is nil, then return Get(key) innerValue
 	if eOpts.loader == nil {
-		return cache.Get(cacheKey)
+		return cache.get(cacheKey)
+	}
+
+	if cache.hotKeys != nil {
+		cache.hotKeys.touch(cacheKey)
 	}
 
 	cache.lock.RLock()
@@ -185,7 +337,7 @@ func (cache *LRUCache) Load(cacheKey string, opts ...EntryOption) (*Value, bool)
 	// check cache hist or not, and if cache hist and expired, async refresh this CacheEntry
 	if ok {
 		entry := e.Value.(*internalEntry)
-		expired := entry.expiration <= time.Now().UnixNano()
+		expired := entry.expiration <= cache.opts.clock.Now().UnixNano()
 		// not expired or async load just return innerValue in cache
 		if !expired || !eOpts.syncLoad {
 			defer cache.lock.RUnlock()
@@ -202,48 +354,118 @@ func (cache *LRUCache) Load(cacheKey string, opts ...EntryOption) (*Value, bool)
 	}
 	cache.lock.RUnlock()
 
+	var evicted []*internalEntry
+	defer func() {
+		cache.notifyEvicted(evicted)
+	}()
+
 	cache.lock.Lock()
 	defer cache.lock.Unlock()
 	e, ok = cache.values[cacheKey]
 	if ok {
-		return e.Value.(*internalEntry).innerValue, true
-	}
-
-	// check cache if full
-	if cache.lruList.Len() >= cache.cleanFullThreshold {
-		// attention: if size larger or equal maxsize, sync clean full!
-		if cache.lruList.Len() >= cache.opts.maxSize {
-			cache.cleanFull()
+		entry := e.Value.(*internalEntry)
+		if entry.expiration > cache.opts.clock.Now().UnixNano() {
+			return entry.innerValue, true
 		}
-
-		select {
-		case cache.cleanFullChan <- struct{}{}:
-			//do nothing
-		default:
-			// do nothing
+		// expired with sync load, load it again
+		cacheEntry := cache.callLoader(cacheKey, eOpts)
+		if eOpts.staleOnCircuitOpen && cacheEntry.innerValue.Err == ErrCircuitOpen {
+			return entry.innerValue, true
 		}
+		e.Value = cacheEntry
+		cache.lruMoveToFront(e)
+		return cacheEntry.innerValue, false
 	}
 
+	// check cache if full
+	evicted = cache.checkFull()
+
 	// call loader
 	cacheEntry := cache.callLoader(cacheKey, eOpts)
-	e = cache.lruInsert(cacheEntry)
-	cache.values[cacheKey] = e
+	cache.addItem(cacheEntry)
 
 	return cacheEntry.innerValue, false
 }
 
 func (cache *LRUCache) Delete(cacheKey string) {
+	call := cache.intercept(OpDelete, cacheKey)
+	cache.delete(cacheKey)
+	cache.publish(InvalidateKey, cacheKey)
+	if call != nil {
+		call.done(false, nil)
+	}
+}
+
+func (cache *LRUCache) delete(cacheKey string) {
 	cache.lock.Lock()
 	defer cache.lock.Unlock()
 
 	// check cache innerValue exist
 	if v, ok := cache.values[cacheKey]; ok {
-		cache.lruList.Remove(v)
-		delete(cache.values, cacheKey)
+		cache.deleteItem(v)
+	}
+}
+
+// DeleteByPrefix deletes all elements whose key starts with prefix,
+// returning the number of deleted elements.
+func (cache *LRUCache) DeleteByPrefix(prefix string) int {
+	n := cache.deleteByPrefix(prefix)
+	cache.publish(InvalidatePrefix, prefix)
+	return n
+}
+
+func (cache *LRUCache) deleteByPrefix(prefix string) int {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	var keys []string
+	cache.keys.rangePrefix(prefix, func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	for _, key := range keys {
+		cache.deleteItem(cache.values[key])
+	}
+
+	return len(keys)
+}
+
+// RangePrefix calls f sequentially in key order for each element whose key starts with prefix.
+// If f returns false, range stops the iteration.
+//
+// f is called without holding the cache lock, so it may call other methods of the cache.
+// Elements are those present when RangePrefix is called.
+func (cache *LRUCache) RangePrefix(prefix string, f func(key string, value *Value) bool) {
+	var entries []*internalEntry
+
+	cache.lock.RLock()
+	cache.keys.rangePrefix(prefix, func(key string) bool {
+		entries = append(entries, cache.values[key].Value.(*internalEntry))
+		return true
+	})
+	cache.lock.RUnlock()
+
+	for _, entry := range entries {
+		if !f(entry.key, entry.innerValue) {
+			return
+		}
 	}
 }
 
 func (cache *LRUCache) Get(key string) (*Value, bool) {
+	if call := cache.intercept(OpGet, key); call != nil {
+		v, ok := cache.get(key)
+		call.done(ok, valueErr(v))
+		return v, ok
+	}
+	return cache.get(key)
+}
+
+func (cache *LRUCache) get(key string) (*Value, bool) {
+	if cache.hotKeys != nil {
+		cache.hotKeys.touch(key)
+	}
+
 	cache.lock.RLock()
 	defer cache.lock.RUnlock()
 
@@ -257,12 +479,63 @@ func (cache *LRUCache) Get(key string) (*Value, bool) {
 	return nil, false
 }
 
+// getUnexpired like Get, but return false if the element has expired
+func (cache *LRUCache) getUnexpired(key string) (*Value, bool) {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	e, ok := cache.values[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*internalEntry)
+	if entry.expiration <= cache.opts.clock.Now().UnixNano() {
+		return nil, false
+	}
+	return entry.innerValue, true
+}
+
+// peek returns the element of key, including an expired one, live is true if it is not expired
+func (cache *LRUCache) peek(key string) (v *Value, live bool) {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	e, ok := cache.values[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*internalEntry)
+	return entry.innerValue, entry.expiration > cache.opts.clock.Now().UnixNano()
+}
+
 func (cache *LRUCache) Put(key string, value interface{}, opts ...EntryOption) interface{} {
+	v, _ := cache.replace(key, value, opts...)
+	return v
+}
+
+// replace is Put, also returning true if the key existed
+func (cache *LRUCache) replace(key string, value interface{}, opts ...EntryOption) (interface{}, bool) {
+	if call := cache.intercept(OpPut, key); call != nil {
+		v, replaced := cache.put(key, value, opts...)
+		call.done(replaced, nil)
+		return v, replaced
+	}
+	return cache.put(key, value, opts...)
+}
+
+// put returns the previous element and true if the key existed, otherwise the new element
+func (cache *LRUCache) put(key string, value interface{}, opts ...EntryOption) (interface{}, bool) {
 	eOpts := cache.opts.defaultEntryOpts
 	for _, o := range opts {
 		o(&eOpts)
 	}
 
+	var evicted []*internalEntry
+	defer func() {
+		cache.notifyEvicted(evicted)
+		cache.publish(InvalidatePut, key)
+	}()
+
 	cache.lock.Lock()
 	defer cache.lock.Unlock()
 
@@ -270,7 +543,7 @@ func (cache *LRUCache) Put(key string, value interface{}, opts ...EntryOption) i
 	cacheEntry := &internalEntry{
 		key:        key,
 		innerValue: &Value{Val: value},
-		expiration: time.Now().Add(eOpts.expireAfterWrite).UnixNano(),
+		expiration: cache.opts.clock.Now().Add(eOpts.expireAfterWrite).UnixNano(),
 		refreshed:  atomic.NewBool(false),
 	}
 
@@ -279,13 +552,13 @@ func (cache *LRUCache) Put(key string, value interface{}, opts ...EntryOption) i
 		e.Value = cacheEntry
 		cache.lruMoveToFront(e)
 
-		return pre.(*internalEntry).innerValue
+		return pre.(*internalEntry).innerValue, true
 	}
 
-	e = cache.lruInsert(cacheEntry)
-	cache.values[key] = e
+	evicted = cache.checkFull()
+	cache.addItem(cacheEntry)
 
-	return cacheEntry.innerValue
+	return cacheEntry.innerValue, false
 }
 
 func (cache *LRUCache) Size() int {
@@ -295,6 +568,68 @@ func (cache *LRUCache) Size() int {
 	return len(cache.values)
 }
 
+// Purge deletes all elements, returning the number of deleted elements
+func (cache *LRUCache) Purge() int {
+	cache.lock.Lock()
+	n := len(cache.values)
+	cache.values = make(map[string]*list.Element, cache.opts.maxSize)
+	cache.lruList.Init()
+	cache.keys.clear()
+	cache.lock.Unlock()
+
+	cache.publish(InvalidatePrefix, "")
+	return n
+}
+
+// TTL returns the remaining time to live of key, it is negative if key has expired
+func (cache *LRUCache) TTL(key string) (time.Duration, bool) {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	e, ok := cache.values[key]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(e.Value.(*internalEntry).expiration - cache.opts.clock.Now().UnixNano()), true
+}
+
+// SampleKeys returns at most n keys from the most recently used, with their remaining time to live
+func (cache *LRUCache) SampleKeys(n int) []KeyInfo {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	now := cache.opts.clock.Now().UnixNano()
+	keys := make([]KeyInfo, 0, n)
+	for e := cache.lruList.Front(); e != nil && len(keys) < n; e = e.Next() {
+		entry := e.Value.(*internalEntry)
+		keys = append(keys, KeyInfo{Key: entry.key, TTL: time.Duration(entry.expiration - now)})
+	}
+	return keys
+}
+
+// Config returns the configuration of the cache
+func (cache *LRUCache) Config() map[string]interface{} {
+	return map[string]interface{}{
+		"max_size":                     cache.opts.maxSize,
+		"clean_size":                   cache.opts.cleanSize,
+		"clean_duration":               cache.opts.cleanDuration.String(),
+		"clean_full_threshold_percent": cache.opts.cleanFullThresholdPercent,
+		"load_timeout":                 cache.opts.defaultEntryOpts.loadTimeout.String(),
+		"sync_load":                    cache.opts.defaultEntryOpts.syncLoad,
+		"expire_after_write":           cache.opts.defaultEntryOpts.expireAfterWrite.String(),
+		"invalidation":                 cache.opts.invalidationName,
+		"circuit":                      cache.circuitState(),
+	}
+}
+
+// circuitState returns the state of the circuit breaker, empty if not set
+func (cache *LRUCache) circuitState() string {
+	if cache.breaker == nil {
+		return ""
+	}
+	return cache.breaker.stateName()
+}
+
 func (cache *LRUCache) CompareAndSwap(key string, old, new interface{}, opts ...EntryOption) (interface{}, bool) {
 	eOpts := cache.opts.defaultEntryOpts
 	for _, o := range opts {
@@ -320,6 +655,17 @@ func (cache *LRUCache) CompareAndSwap(key string, old, new interface{}, opts ...
 	// equal
 	cache.lock.RUnlock()
 
+	var (
+		evicted []*internalEntry
+		swapped bool
+	)
+	defer func() {
+		cache.notifyEvicted(evicted)
+		if swapped {
+			cache.publish(InvalidatePut, key)
+		}
+	}()
+
 	cache.lock.Lock()
 	defer cache.lock.Unlock()
 
@@ -338,7 +684,7 @@ func (cache *LRUCache) CompareAndSwap(key string, old, new interface{}, opts ...
 	cacheEntry := &internalEntry{
 		key:        key,
 		innerValue: &Value{Val: new},
-		expiration: time.Now().Add(eOpts.expireAfterWrite).UnixNano(),
+		expiration: cache.opts.clock.Now().Add(eOpts.expireAfterWrite).UnixNano(),
 		refreshed:  atomic.NewBool(false),
 	}
 
@@ -347,9 +693,29 @@ func (cache *LRUCache) CompareAndSwap(key string, old, new interface{}, opts ...
 		e.Value = cacheEntry
 		cache.lruMoveToFront(e)
 	} else {
-		e = cache.lruInsert(cacheEntry)
-		cache.values[key] = e
+		evicted = cache.checkFull()
+		cache.addItem(cacheEntry)
 	}
+	swapped = true
 
 	return new, true
 }
+
+// publish broadcasts an invalidation to replicas if invalidation bus is set,
+// it must be called without holding the cache lock.
+func (cache *LRUCache) publish(op InvalidationOp, key string) {
+	if cache.invalidator == nil {
+		return
+	}
+	cache.invalidator.publish(op, key)
+}
+
+// applyInvalidation drops the local copies of keys invalidated by a replica
+func (cache *LRUCache) applyInvalidation(msg Invalidation) {
+	switch msg.Op {
+	case InvalidateKey, InvalidatePut:
+		cache.delete(msg.Key)
+	case InvalidatePrefix:
+		cache.deleteByPrefix(msg.Key)
+	}
+}
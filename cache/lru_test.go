@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache_DeleteByPrefix(t *testing.T) {
+	cache := NewLRUCache()
+	cache.Put("tenant:1:user:1", 1)
+	cache.Put("tenant:1:user:2", 2)
+	cache.Put("tenant:10:user:1", 3)
+	cache.Put("tenant:2:user:1", 4)
+
+	assert.Equal(t, 2, cache.DeleteByPrefix("tenant:1:"))
+	assert.Equal(t, 2, cache.Size())
+
+	_, ok := cache.Get("tenant:1:user:1")
+	assert.False(t, ok)
+	_, ok = cache.Get("tenant:10:user:1")
+	assert.True(t, ok)
+
+	assert.Equal(t, 0, cache.DeleteByPrefix("tenant:3:"))
+	assert.Equal(t, 2, cache.DeleteByPrefix(""))
+	assert.Equal(t, 0, cache.Size())
+}
+
+func TestLRUCache_RangePrefix(t *testing.T) {
+	cache := NewLRUCache()
+	for _, k := range []string{"b:2", "a:1", "b:1", "b:3", "c:1", "b"} {
+		cache.Put(k, k)
+	}
+	cache.Delete("b:3")
+
+	var keys []string
+	cache.RangePrefix("b:", func(key string, value *Value) bool {
+		assert.Equal(t, key, value.Val)
+		keys = append(keys, key)
+		return true
+	})
+	assert.Equal(t, []string{"b:1", "b:2"}, keys)
+
+	keys = keys[:0]
+	cache.RangePrefix("b", func(key string, value *Value) bool {
+		keys = append(keys, key)
+		// modify cache in callback must not dead lock
+		cache.Delete(key)
+		return len(keys) < 2
+	})
+	assert.Equal(t, []string{"b", "b:1"}, keys)
+	assert.Equal(t, 3, cache.Size())
+}
@@ -22,6 +22,28 @@ type Options struct {
 
 	// default options for each kv entry
 	defaultEntryOpts EntryOptions
+
+	// onEvicted is called with entries removed because the cache is full
+	onEvicted func(key string, value *Value)
+
+	// clock provides time for expiration, refresh and cleaning
+	clock Clock
+
+	// circuit breaker options, no circuit breaker if failureThreshold is 0
+	failureThreshold int
+	openTimeout      time.Duration
+
+	// hot keys options, hot keys are not tracked if both are unset
+	hotKeysCapacity int
+	hotKeyQPS       int
+	onHotKey        func(key string, qps int)
+
+	// interceptors observe operations, nil for no interceptor
+	interceptors []Interceptor
+
+	// invalidation options, replicas share the same name on the bus
+	invalidationName string
+	invalidationBus  InvalidationBus
 }
 
 // Option ...
@@ -35,6 +57,7 @@ func NewOptions() Options {
 		maxSize:                   defaultMaxSize,
 		cleanFullThresholdPercent: defaultCleanFullThresholdPercent,
 		defaultEntryOpts:          NewEntryOptions(),
+		clock:                     SystemClock{},
 	}
 }
 
@@ -64,6 +87,60 @@ func DefaultEntryOpts(opts ...EntryOption) Option {
 		for _, eOpt := range opts {
 			eOpt(&eOpts)
 		}
+		options.defaultEntryOpts = eOpts
+	}
+}
+
+// WithInvalidation set the bus broadcasting Delete, DeleteByPrefix, Put and CompareAndSwap
+// of the cache to its replicas, which are the caches with the same name on the bus.
+// Replicas drop their copies of the invalidated keys.
+func WithInvalidation(name string, bus InvalidationBus) Option {
+	return func(options *Options) {
+		options.invalidationName = name
+		options.invalidationBus = bus
+	}
+}
+
+// WithClock set the clock of the cache, use FakeClock to test expiration deterministically
+func WithClock(c Clock) Option {
+	return func(options *Options) {
+		options.clock = c
+	}
+}
+
+// WithCircuitBreaker set a circuit breaker around the loaders of the cache: after failureThreshold
+// consecutive failed loads, loads fail fast with ErrCircuitOpen for openTimeout, then one load
+// is let through as a probe whose success closes the circuit.
+func WithCircuitBreaker(failureThreshold int, openTimeout time.Duration) Option {
+	return func(options *Options) {
+		options.failureThreshold = failureThreshold
+		options.openTimeout = openTimeout
+	}
+}
+
+// TrackHotKeys track the most frequently accessed keys by Get and Load, reported by HotKeys.
+// Keys accessed more than 1/capacity of all accesses are always tracked.
+func TrackHotKeys(capacity int) Option {
+	return func(options *Options) {
+		options.hotKeysCapacity = capacity
+	}
+}
+
+// OnHotKey set a callback called when the accesses of a tracked key within a second reach qps,
+// at most once a second for each key. It is called synchronously by Get or Load without holding
+// the cache lock. Hot keys are tracked with the default capacity unless TrackHotKeys is set.
+func OnHotKey(qps int, f func(key string, qps int)) Option {
+	return func(options *Options) {
+		options.hotKeyQPS = qps
+		options.onHotKey = f
+	}
+}
+
+// OnEvicted set a callback called with each entry removed because the cache is full.
+// It is called without holding the cache lock.
+func OnEvicted(f func(key string, value *Value)) Option {
+	return func(options *Options) {
+		options.onEvicted = f
 	}
 }
 
@@ -74,6 +151,10 @@ type EntryOptions struct {
 
 	expireAfterWrite  time.Duration
 	refreshAfterWrite time.Duration
+
+	retry RetryPolicy
+	// staleOnCircuitOpen keeps the expired value when the circuit is open
+	staleOnCircuitOpen bool
 }
 
 type EntryOption func(options *EntryOptions)
@@ -118,3 +199,17 @@ func RefreshOption(d time.Duration) EntryOption {
 		options.refreshAfterWrite = d
 	}
 }
+
+// WithRetryPolicy set the retry policy of the loader
+func WithRetryPolicy(p RetryPolicy) EntryOption {
+	return func(options *EntryOptions) {
+		options.retry = p
+	}
+}
+
+// StaleOnCircuitOpen serve the expired value instead of ErrCircuitOpen when the circuit is open
+func StaleOnCircuitOpen(v bool) EntryOption {
+	return func(options *EntryOptions) {
+		options.staleOnCircuitOpen = v
+	}
+}
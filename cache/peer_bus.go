@@ -0,0 +1,229 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPeerQueueSize     = 1024
+	defaultPeerDialTimeout   = time.Second
+	defaultPeerRetryInterval = 100 * time.Millisecond
+)
+
+// PeerBus an InvalidationBus over TCP, invalidations are sent to every peer as
+// newline-delimited JSON. Each peer has one connection and one sending goroutine,
+// so invalidations are received in publish order.
+//
+// Peers are set by SetPeers, there is no discovery.
+type PeerBus struct {
+	ln   net.Listener
+	subs subscribers
+
+	mu     sync.Mutex
+	peers  map[string]*peerSender
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// NewPeerBus new a peer bus listening on addr, e.g. "127.0.0.1:0"
+func NewPeerBus(addr string) (*PeerBus, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &PeerBus{
+		ln:    ln,
+		peers: make(map[string]*peerSender),
+		conns: make(map[net.Conn]struct{}),
+	}
+	go b.accept()
+
+	return b, nil
+}
+
+// Addr returns the listening address of the bus
+func (b *PeerBus) Addr() net.Addr {
+	return b.ln.Addr()
+}
+
+// SetPeers sets the addresses of the peers, replacing the previous ones
+func (b *PeerBus) SetPeers(addrs ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	keep := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		keep[addr] = true
+		if _, ok := b.peers[addr]; !ok {
+			b.peers[addr] = newPeerSender(addr)
+		}
+	}
+	for addr, p := range b.peers {
+		if !keep[addr] {
+			p.close()
+			delete(b.peers, addr)
+		}
+	}
+}
+
+// Publish delivers msg to local subscribers and queues it to every peer.
+// If the queue of a peer is full, msg is dropped for that peer.
+func (b *PeerBus) Publish(msg Invalidation) error {
+	b.subs.deliver(msg)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrCacheClosed
+	}
+	for _, p := range b.peers {
+		p.send(msg)
+	}
+	return nil
+}
+
+// Subscribe ...
+func (b *PeerBus) Subscribe(f func(msg Invalidation)) func() {
+	return b.subs.subscribe(f)
+}
+
+// Close stops listening and closes all connections
+func (b *PeerBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	for _, p := range b.peers {
+		p.close()
+	}
+	for conn := range b.conns {
+		_ = conn.Close()
+	}
+	return b.ln.Close()
+}
+
+func (b *PeerBus) accept() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		b.conns[conn] = struct{}{}
+		b.mu.Unlock()
+
+		go b.receive(conn)
+	}
+}
+
+func (b *PeerBus) receive(conn net.Conn) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, conn)
+		b.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var msg Invalidation
+		if err := dec.Decode(&msg); err != nil {
+			return
+		}
+		b.subs.deliver(msg)
+	}
+}
+
+// peerSender sends queued invalidations to a peer, reconnecting on failure
+type peerSender struct {
+	addr  string
+	queue chan Invalidation
+	stop  chan struct{}
+}
+
+func newPeerSender(addr string) *peerSender {
+	p := &peerSender{
+		addr:  addr,
+		queue: make(chan Invalidation, defaultPeerQueueSize),
+		stop:  make(chan struct{}),
+	}
+	go p.run()
+
+	return p
+}
+
+func (p *peerSender) send(msg Invalidation) {
+	select {
+	case p.queue <- msg:
+	default:
+		// peer is too slow or down, drop it
+	}
+}
+
+func (p *peerSender) close() {
+	close(p.stop)
+}
+
+func (p *peerSender) run() {
+	var (
+		conn net.Conn
+		enc  *json.Encoder
+	)
+	defer func() {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}()
+
+	for {
+		var msg Invalidation
+		select {
+		case msg = <-p.queue:
+		case <-p.stop:
+			return
+		}
+
+		// retry until sent, the receiver drops duplicates
+		for {
+			if conn == nil {
+				c, err := net.DialTimeout("tcp", p.addr, defaultPeerDialTimeout)
+				if err == nil {
+					conn, enc = c, json.NewEncoder(c)
+				}
+			}
+			if conn != nil {
+				if err := enc.Encode(&msg); err == nil {
+					break
+				}
+				_ = conn.Close()
+				conn = nil
+			}
+
+			select {
+			case <-time.After(defaultPeerRetryInterval):
+			case <-p.stop:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultRetryBaseDelay = 50 * time.Millisecond
+)
+
+// RetryPolicy retries a failed loader with exponential backoff, the zero value does not retry.
+// All attempts share the loadTimeout of the entry.
+type RetryPolicy struct {
+	// MaxAttempts max calls of the loader including the first one
+	MaxAttempts int
+
+	// BaseDelay delay before the second attempt, doubled for each following attempt,
+	// 50ms if not set
+	BaseDelay time.Duration
+
+	// MaxDelay max delay between attempts, no limit if not set
+	MaxDelay time.Duration
+
+	// Jitter in [0, 1] randomly shortens each delay by up to this fraction
+	Jitter float64
+
+	// Retryable reports whether an error should be retried, all errors are retried if nil
+	Retryable func(err error) bool
+}
+
+// backoff returns the delay after the attempt-th failed attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	if d <= 0 {
+		d = defaultRetryBaseDelay
+	}
+	for i := 1; i < attempt; i++ {
+		if p.MaxDelay > 0 && d >= p.MaxDelay {
+			break
+		}
+		// overflow
+		if d > d<<1 {
+			break
+		}
+		d <<= 1
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		d -= time.Duration(rand.Float64() * jitter * float64(d))
+	}
+	return d
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+// call calls loader until it succeeds, the error is not retryable, attempts run out
+// or stop is closed, returning the last result
+func (p RetryPolicy) call(key string, loader Loader, clock Clock, stop <-chan struct{}) *Value {
+	for attempt := 1; ; attempt++ {
+		ret := loader(key)
+		if ret.Err == nil || attempt >= p.MaxAttempts || !p.retryable(ret.Err) {
+			return ret
+		}
+
+		wait := make(chan struct{})
+		timer := clock.AfterFunc(p.backoff(attempt), func() {
+			close(wait)
+		})
+		select {
+		case <-wait:
+		case <-stop:
+			timer.Stop()
+			return ret
+		}
+	}
+}
@@ -59,6 +59,46 @@ type Map struct {
 	// map, the dirty map will be promoted to the read map (in the unamended
 	// state) and the next store to the map will make a new dirty copy.
 	misses int
+
+	// clock provides time for LoaderWithExpired, nil means SystemClock.
+	clock Clock
+
+	// loads coalesces concurrent calls of Loader and LoaderWithExpired for a key.
+	loads flightGroup
+	// refreshes coalesces asynchronous refreshes of LoaderWithExpired for a key,
+	// separate from loads as a refresh does not store a missing key.
+	refreshes flightGroup
+
+	// refreshSem bounds the refreshes of LoaderWithExpired running at a time,
+	// made with refreshWorkers slots on first use.
+	refreshWorkers int
+	refreshOnce    sync.Once
+	refreshSem     chan struct{}
+
+	// counts the number of keys with a value, striped to avoid contention, see Len.
+	counts [lenStripes]lenStripe
+}
+
+// lenStripes the number of counter stripes of Len.
+const lenStripes = 16
+
+// lenStripe a counter of Len padded to its own cache line.
+type lenStripe struct {
+	n int64
+	_ [56]byte
+}
+
+// SetClock sets the clock used by LoaderWithExpired.
+// It must be called before the Map is used.
+func (m *Map) SetClock(c Clock) {
+	m.clock = c
+}
+
+func (m *Map) now() int64 {
+	if m.clock == nil {
+		return time.Now().UnixNano()
+	}
+	return m.clock.Now().UnixNano()
 }
 
 // readOnly is an immutable struct stored atomically in the Map.read field.
@@ -102,6 +142,15 @@ func newEntry(i interface{}) *entry {
 // value is present.
 // The ok result indicates whether value was found in the map.
 func (m *Map) Load(key interface{}) (value interface{}, ok bool) {
+	e, ok := m.loadEntry(key)
+	if !ok {
+		return nil, false
+	}
+	return e.load()
+}
+
+// loadEntry returns the entry for a key, which may be deleted or expunged.
+func (m *Map) loadEntry(key interface{}) (*entry, bool) {
 	read, _ := m.read.Load().(readOnly)
 	e, ok := read.m[key]
 	if !ok && read.amended {
@@ -120,10 +169,7 @@ func (m *Map) Load(key interface{}) (value interface{}, ok bool) {
 		}
 		m.mu.Unlock()
 	}
-	if !ok {
-		return nil, false
-	}
-	return e.load()
+	return e, ok
 }
 
 func (e *entry) load() (value interface{}, ok bool) {
@@ -136,48 +182,7 @@ func (e *entry) load() (value interface{}, ok bool) {
 
 // Store sets the value for a key.
 func (m *Map) Store(key, value interface{}) {
-	read, _ := m.read.Load().(readOnly)
-	if e, ok := read.m[key]; ok && e.tryStore(&value) {
-		return
-	}
-
-	m.mu.Lock()
-	read, _ = m.read.Load().(readOnly)
-	if e, ok := read.m[key]; ok {
-		if e.unexpungeLocked() {
-			// The entry was previously expunged, which implies that there is a
-			// non-nil dirty map and this entry is not in it.
-			m.dirty[key] = e
-		}
-		e.storeLocked(&value)
-	} else if e, ok := m.dirty[key]; ok {
-		e.storeLocked(&value)
-	} else {
-		if !read.amended {
-			// We're adding the first new key to the dirty map.
-			// Make sure it is allocated and mark the read-only map as incomplete.
-			m.dirtyLocked()
-			m.read.Store(readOnly{m: read.m, amended: true})
-		}
-		m.dirty[key] = newEntry(value)
-	}
-	m.mu.Unlock()
-}
-
-// tryStore stores a value if the entry has not been expunged.
-//
-// If the entry is expunged, tryStore returns false and leaves the entry
-// unchanged.
-func (e *entry) tryStore(i *interface{}) bool {
-	for {
-		p := atomic.LoadPointer(&e.p)
-		if p == expunged {
-			return false
-		}
-		if atomic.CompareAndSwapPointer(&e.p, p, unsafe.Pointer(i)) {
-			return true
-		}
-	}
+	_, _ = m.Swap(key, value)
 }
 
 // unexpungeLocked ensures that the entry is not marked as expunged.
@@ -188,13 +193,6 @@ func (e *entry) unexpungeLocked() (wasExpunged bool) {
 	return atomic.CompareAndSwapPointer(&e.p, expunged, nil)
 }
 
-// storeLocked unconditionally stores a value to the entry.
-//
-// The entry must be known not to be expunged.
-func (e *entry) storeLocked(i *interface{}) {
-	atomic.StorePointer(&e.p, unsafe.Pointer(i))
-}
-
 // LoadOrStore returns the existing value for the key if present.
 // Otherwise, it stores and returns the given value.
 // The loaded result is true if the value was loaded, false if stored.
@@ -204,6 +202,9 @@ func (m *Map) LoadOrStore(key, value interface{}) (actual interface{}, loaded bo
 	if e, ok := read.m[key]; ok {
 		actual, loaded, ok := e.tryLoadOrStore(value)
 		if ok {
+			if !loaded {
+				m.addLen(e, 1)
+			}
 			return actual, loaded
 		}
 	}
@@ -215,8 +216,14 @@ func (m *Map) LoadOrStore(key, value interface{}) (actual interface{}, loaded bo
 			m.dirty[key] = e
 		}
 		actual, loaded, _ = e.tryLoadOrStore(value)
+		if !loaded {
+			m.addLen(e, 1)
+		}
 	} else if e, ok := m.dirty[key]; ok {
 		actual, loaded, _ = e.tryLoadOrStore(value)
+		if !loaded {
+			m.addLen(e, 1)
+		}
 		m.missLocked()
 	} else {
 		if !read.amended {
@@ -225,7 +232,9 @@ func (m *Map) LoadOrStore(key, value interface{}) (actual interface{}, loaded bo
 			m.dirtyLocked()
 			m.read.Store(readOnly{m: read.m, amended: true})
 		}
-		m.dirty[key] = newEntry(value)
+		e := newEntry(value)
+		m.dirty[key] = e
+		m.addLen(e, 1)
 		actual, loaded = value, false
 	}
 	m.mu.Unlock()
@@ -265,8 +274,9 @@ func (e *entry) tryLoadOrStore(i interface{}) (actual interface{}, loaded, ok bo
 	}
 }
 
-// Delete deletes the value for a key.
-func (m *Map) Delete(key interface{}) {
+// LoadAndDelete deletes the value for a key, returning the previous value if any.
+// The loaded result reports whether the key was present.
+func (m *Map) LoadAndDelete(key interface{}) (value interface{}, loaded bool) {
 	read, _ := m.read.Load().(readOnly)
 	e, ok := read.m[key]
 	if !ok && read.amended {
@@ -274,25 +284,260 @@ func (m *Map) Delete(key interface{}) {
 		read, _ = m.read.Load().(readOnly)
 		e, ok = read.m[key]
 		if !ok && read.amended {
+			e, ok = m.dirty[key]
 			delete(m.dirty, key)
+			// Regardless of whether the entry was present, record a miss: this key
+			// will take the slow path until the dirty map is promoted to the read
+			// map.
+			m.missLocked()
 		}
 		m.mu.Unlock()
 	}
 	if ok {
-		e.delete()
+		if value, loaded = e.delete(); loaded {
+			m.addLen(e, -1)
+		}
 	}
+	return value, loaded
+}
+
+// Delete deletes the value for a key.
+func (m *Map) Delete(key interface{}) {
+	m.LoadAndDelete(key)
 }
 
-func (e *entry) delete() (hadValue bool) {
+func (e *entry) delete() (value interface{}, ok bool) {
 	for {
 		p := atomic.LoadPointer(&e.p)
 		if p == nil || p == expunged {
+			return nil, false
+		}
+		if atomic.CompareAndSwapPointer(&e.p, p, nil) {
+			return *(*interface{})(p), true
+		}
+	}
+}
+
+// trySwap swaps a value if the entry has not been expunged.
+//
+// If the entry is expunged, trySwap returns false and leaves the entry
+// unchanged.
+func (e *entry) trySwap(i *interface{}) (unsafe.Pointer, bool) {
+	for {
+		p := atomic.LoadPointer(&e.p)
+		if p == expunged {
+			return nil, false
+		}
+		if atomic.CompareAndSwapPointer(&e.p, p, unsafe.Pointer(i)) {
+			return p, true
+		}
+	}
+}
+
+// swapLocked unconditionally swaps a value into the entry.
+//
+// The entry must be known not to be expunged.
+func (e *entry) swapLocked(i *interface{}) unsafe.Pointer {
+	return atomic.SwapPointer(&e.p, unsafe.Pointer(i))
+}
+
+// Swap swaps the value for a key and returns the previous value if any.
+// The loaded result reports whether the key was present.
+func (m *Map) Swap(key, value interface{}) (previous interface{}, loaded bool) {
+	read, _ := m.read.Load().(readOnly)
+	if e, ok := read.m[key]; ok {
+		if p, ok := e.trySwap(&value); ok {
+			if p == nil {
+				m.addLen(e, 1)
+				return nil, false
+			}
+			return *(*interface{})(p), true
+		}
+	}
+
+	m.mu.Lock()
+	read, _ = m.read.Load().(readOnly)
+	if e, ok := read.m[key]; ok {
+		if e.unexpungeLocked() {
+			// The entry was previously expunged, which implies that there is a
+			// non-nil dirty map and this entry is not in it.
+			m.dirty[key] = e
+		}
+		if p := e.swapLocked(&value); p != nil {
+			previous, loaded = *(*interface{})(p), true
+		} else {
+			m.addLen(e, 1)
+		}
+	} else if e, ok := m.dirty[key]; ok {
+		if p := e.swapLocked(&value); p != nil {
+			previous, loaded = *(*interface{})(p), true
+		} else {
+			m.addLen(e, 1)
+		}
+	} else {
+		if !read.amended {
+			// We're adding the first new key to the dirty map.
+			// Make sure it is allocated and mark the read-only map as incomplete.
+			m.dirtyLocked()
+			m.read.Store(readOnly{m: read.m, amended: true})
+		}
+		e := newEntry(value)
+		m.dirty[key] = e
+		m.addLen(e, 1)
+	}
+	m.mu.Unlock()
+	return previous, loaded
+}
+
+// CompareAndSwap swaps the old and new values for key
+// if the value stored in the map is equal to old.
+// The old value must be of a comparable type.
+func (m *Map) CompareAndSwap(key, old, new interface{}) bool {
+	read, _ := m.read.Load().(readOnly)
+	if e, ok := read.m[key]; ok {
+		return e.tryCompareAndSwap(old, new)
+	} else if !read.amended {
+		return false // No existing value for key.
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	read, _ = m.read.Load().(readOnly)
+	swapped := false
+	if e, ok := read.m[key]; ok {
+		swapped = e.tryCompareAndSwap(old, new)
+	} else if e, ok := m.dirty[key]; ok {
+		swapped = e.tryCompareAndSwap(old, new)
+		// We needed to lock mu in order to load the entry for key,
+		// and the operation didn't change the set of keys in the map
+		// (so it would be made more efficient by promoting the dirty
+		// map to read-only).
+		// Count it as a miss so that we will eventually switch to the
+		// more efficient steady state.
+		m.missLocked()
+	}
+	return swapped
+}
+
+// tryCompareAndSwap compare the entry with the given old value and swaps
+// it with a new value if the entry is equal to the old value, and the entry
+// has not been expunged.
+//
+// If the entry is expunged, tryCompareAndSwap returns false and leaves
+// the entry unchanged.
+func (e *entry) tryCompareAndSwap(old, new interface{}) bool {
+	p := atomic.LoadPointer(&e.p)
+	if p == nil || p == expunged || *(*interface{})(p) != old {
+		return false
+	}
+
+	// Copy the interface after the first load to make this method more amenable
+	// to escape analysis: if the comparison fails from the start, we shouldn't
+	// bother heap-allocating an interface value to store.
+	nc := new
+	for {
+		if atomic.CompareAndSwapPointer(&e.p, p, unsafe.Pointer(&nc)) {
+			return true
+		}
+		p = atomic.LoadPointer(&e.p)
+		if p == nil || p == expunged || *(*interface{})(p) != old {
+			return false
+		}
+	}
+}
+
+// CompareAndDelete deletes the entry for key if its value is equal to old.
+// The old value must be of a comparable type.
+//
+// If there is no current value for key in the map, CompareAndDelete
+// returns false (even if the old value is the nil interface value).
+func (m *Map) CompareAndDelete(key, old interface{}) (deleted bool) {
+	read, _ := m.read.Load().(readOnly)
+	e, ok := read.m[key]
+	if !ok && read.amended {
+		m.mu.Lock()
+		read, _ = m.read.Load().(readOnly)
+		e, ok = read.m[key]
+		if !ok && read.amended {
+			e, ok = m.dirty[key]
+			// Don't delete key from m.dirty: we still need to do the "compare" part
+			// of the operation. The entry will eventually be expunged when the
+			// dirty map is promoted to the read map.
+			//
+			// Regardless of whether the entry was present, record a miss: this key
+			// will take the slow path until the dirty map is promoted to the read
+			// map.
+			m.missLocked()
+		}
+		m.mu.Unlock()
+	}
+	for ok {
+		p := atomic.LoadPointer(&e.p)
+		if p == nil || p == expunged || *(*interface{})(p) != old {
 			return false
 		}
 		if atomic.CompareAndSwapPointer(&e.p, p, nil) {
+			m.addLen(e, -1)
 			return true
 		}
 	}
+	return false
+}
+
+// Clear deletes all the entries, resulting in an empty Map.
+func (m *Map) Clear() {
+	read, _ := m.read.Load().(readOnly)
+	if len(read.m) == 0 && !read.amended {
+		// Avoid allocating a new readOnly when the map is already clear.
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	read, _ = m.read.Load().(readOnly)
+	if len(read.m) > 0 || read.amended {
+		m.read.Store(readOnly{})
+	}
+
+	// Expunge the dropped entries, so stores racing with Clear on them take
+	// the slow path and are counted in the new maps.
+	for _, e := range read.m {
+		m.expungeDroppedLocked(e)
+	}
+	for _, e := range m.dirty {
+		m.expungeDroppedLocked(e)
+	}
+	m.dirty = nil
+	// Don't immediately promote the newly-cleared dirty map on the next operation.
+	m.misses = 0
+}
+
+// expungeDroppedLocked marks an entry dropped by Clear as expunged, uncounting its value.
+func (m *Map) expungeDroppedLocked(e *entry) {
+	if p := atomic.SwapPointer(&e.p, expunged); p != nil && p != expunged {
+		m.addLen(e, -1)
+	}
+}
+
+// Len returns the number of keys with a value in the map in constant time.
+// It is exact when the map is not modified concurrently.
+func (m *Map) Len() int {
+	var n int64
+	for i := range m.counts {
+		n += atomic.LoadInt64(&m.counts[i].n)
+	}
+	// a delete may be counted before the store it deletes
+	if n < 0 {
+		return 0
+	}
+	return int(n)
+}
+
+// addLen adds delta to the counter stripe of e, spreading concurrent updates over stripes.
+func (m *Map) addLen(e *entry, delta int64) {
+	i := (uintptr(unsafe.Pointer(e)) >> 4) % lenStripes
+	atomic.AddInt64(&m.counts[i].n, delta)
 }
 
 // Range calls valFunc sequentially for each key and value present in the map.
@@ -375,174 +620,192 @@ func (e *entry) tryExpungeLocked() (isExpunged bool) {
 
 // Loader returns the existing value for the key if present.
 // Otherwise, it stores and returns the given function result value.
-// When stores given value, only allow only one goroutine to do function.
+// Concurrent callers of a key share one call of f and its error, f is called without
+// holding the lock of the map, so keys are loaded in parallel.
 func (m *Map) Loader(key string, f func() (interface{}, error)) (interface{}, error) {
-	// Avoid locking if it's a clean hit.
-	read, _ := m.read.Load().(readOnly)
-	if e, ok := read.m[key]; ok {
-		p := atomic.LoadPointer(&e.p)
-		if p != nil && p != expunged {
-			return *(*interface{})(p), nil
-		}
+	if v, ok := m.Load(key); ok {
+		return v, nil
 	}
 
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	read, _ = m.read.Load().(readOnly)
-	if e, ok := read.m[key]; ok {
-		if e.unexpungeLocked() {
-			m.dirty[key] = e
-			// do f()
-			value, err := f()
-			if err != nil {
-				return nil, err
-			}
-			e.storeLocked(&value)
-			return value, nil
-		} else if atomic.LoadPointer(&e.p) == nil {
-			// do f()
-			value, err := f()
-			if err != nil {
-				return nil, err
-			}
-			e.storeLocked(&value)
-			return value, nil
-		} else {
-			return *(*interface{})(e.p), nil
-		}
-	} else if e, ok := m.dirty[key]; ok {
-		m.missLocked()
-		return *(*interface{})(e.p), nil
-	} else {
-		if !read.amended {
-			// We're adding the first new key to the dirty map.
-			// Make sure it is allocated and mark the read-only map as incomplete.
-			m.dirtyLocked()
-			m.read.Store(readOnly{m: read.m, amended: true})
+	ret, _ := m.loads.do(key, func() *Value {
+		// stored by the previous call of f
+		if v, ok := m.Load(key); ok {
+			return &Value{Val: v}
 		}
 
 		// do f()
 		value, err := f()
 		if err != nil {
-			return nil, err
+			return &Value{Err: err}
 		}
-		m.dirty[key] = newEntry(value)
-		return value, nil
-	}
+		// keep the value stored while f was running
+		actual, _ := m.LoadOrStore(key, value)
+		return &Value{Val: actual}
+	})
+	return ret.Val, ret.Err
 }
 
+// defaultRefreshWorkers the number of refreshes of LoaderWithExpired running at a time by default
+const defaultRefreshWorkers = 16
+
+// expiredItem a value of LoaderWithExpired, stored by pointer so readers share refreshing
 type expiredItem struct {
 	expiredTime int64
 	value       interface{}
-	refreshed   uint64
+	// refreshing is 1 while the item is refreshed
+	refreshing uint32
 }
 
-// LoaderWithExpired like Loader, but return expired value and asyncRefresh cache value.
-func (m *Map) LoaderWithExpired(key string, f func() (interface{}, error), expireTime time.Duration) (interface{}, error) {
-	// Avoid locking if it's a clean hit.
-	read, _ := m.read.Load().(readOnly)
-	if e, ok := read.m[key]; ok {
-		p := atomic.LoadPointer(&e.p)
-		if p != nil && p != expunged {
-			i := (*(*interface{})(e.p)).(expiredItem)
-			// refresh async after expired
-			if i.expiredTime <= time.Now().UnixNano() {
-				if atomic.CompareAndSwapUint64(&(i.refreshed), 0, 1) {
-					go func() {
-						e.asyncRefresh(f, expireTime)
-					}()
-				}
-			}
-			return i.value, nil
-		}
+// ExpiredOptions options of LoaderWithExpired
+type ExpiredOptions struct {
+	// maxStaleness how long an expired value can be served while refreshing, no bound if 0
+	maxStaleness time.Duration
+
+	// onRefreshError is called with errors of asynchronous refreshes
+	onRefreshError func(key string, err error)
+}
+
+// ExpiredOption ...
+type ExpiredOption func(options *ExpiredOptions)
+
+// MaxStaleness set how long an expired value can be served while it is refreshed asynchronously,
+// a value expired for longer is loaded synchronously and not served if loading fails
+func MaxStaleness(d time.Duration) ExpiredOption {
+	return func(options *ExpiredOptions) {
+		options.maxStaleness = d
 	}
+}
 
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	read, _ = m.read.Load().(readOnly)
-	if e, ok := read.m[key]; ok {
-		if e.unexpungeLocked() {
-			m.dirty[key] = e
-			// do f()
-			value, err := f()
-			if err != nil {
-				return nil, err
-			}
+// OnRefreshError set a callback called with the errors of asynchronous refreshes,
+// the expired value is kept and refreshed again on a later call
+func OnRefreshError(f func(key string, err error)) ExpiredOption {
+	return func(options *ExpiredOptions) {
+		options.onRefreshError = f
+	}
+}
 
-			item := expiredItem{
-				value:       value,
-				expiredTime: time.Now().UnixNano() + expireTime.Nanoseconds(),
-				refreshed:   0,
-			}
-			i := interface{}(item)
-			e.storeLocked(&i)
-			return value, nil
-		} else if atomic.LoadPointer(&e.p) == nil {
-			// do f()
-			value, err := f()
-			if err != nil {
-				return nil, err
-			}
+// SetRefreshWorkers sets the number of refreshes of LoaderWithExpired running at a time,
+// an expired value is not refreshed while all workers are busy but on a later call.
+// It must be called before the Map is used.
+func (m *Map) SetRefreshWorkers(n int) {
+	m.refreshWorkers = n
+}
 
-			item := expiredItem{
-				value:       value,
-				expiredTime: time.Now().UnixNano() + expireTime.Nanoseconds(),
-				refreshed:   0,
-			}
-			i := interface{}(item)
-			e.storeLocked(&i)
-			return value, nil
-		} else {
-			i := (*(*interface{})(e.p)).(expiredItem)
-			if i.expiredTime <= time.Now().UnixNano() {
-				if atomic.CompareAndSwapUint64(&(i.refreshed), 0, 1) {
-					go func() {
-						e.asyncRefresh(f, expireTime)
-					}()
-				}
-			}
-			return i.value, nil
+func (m *Map) refreshSlots() chan struct{} {
+	m.refreshOnce.Do(func() {
+		n := m.refreshWorkers
+		if n <= 0 {
+			n = defaultRefreshWorkers
 		}
-	} else if e, ok := m.dirty[key]; ok {
-		m.missLocked()
-		i := (*(*interface{})(e.p)).(expiredItem)
-		return i.value, nil
-	} else {
-		if !read.amended {
-			// We're adding the first new key to the dirty map.
-			// Make sure it is allocated and mark the read-only map as incomplete.
-			m.dirtyLocked()
-			m.read.Store(readOnly{m: read.m, amended: true})
+		m.refreshSem = make(chan struct{}, n)
+	})
+	return m.refreshSem
+}
+
+// LoaderWithExpired like Loader, but the value expires after expireTime. An expired value is
+// returned while it is refreshed asynchronously by one call of f, unless it has been expired
+// for longer than MaxStaleness.
+func (m *Map) LoaderWithExpired(key string, f func() (interface{}, error), expireTime time.Duration, opts ...ExpiredOption) (interface{}, error) {
+	var o ExpiredOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if item, ok := m.loadItem(key); ok {
+		now := m.now()
+		if item.expiredTime > now {
+			return item.value, nil
+		}
+		if !o.tooStale(item, now) {
+			m.tryRefresh(key, item, f, expireTime, o)
+			return item.value, nil
+		}
+	}
+
+	ret, _ := m.loads.do(key, func() *Value {
+		// stored by the previous call of f
+		cur, ok := m.loadItem(key)
+		if ok && !o.tooStale(cur, m.now()) {
+			return &Value{Val: cur.value}
 		}
+
 		// do f()
 		value, err := f()
 		if err != nil {
-			return nil, err
+			return &Value{Err: err}
 		}
 
-		item := expiredItem{
-			value:       value,
-			expiredTime: time.Now().UnixNano() + int64(expireTime.Nanoseconds()),
-			refreshed:   0,
+		item := m.newExpiredItem(value, expireTime)
+		if ok && m.CompareAndSwap(key, cur, item) {
+			return &Value{Val: value}
 		}
-		i := interface{}(item)
-		m.dirty[key] = newEntry(i)
-		return value, nil
+		// keep the value stored while f was running
+		actual, _ := m.LoadOrStore(key, item)
+		return &Value{Val: actual.(*expiredItem).value}
+	})
+	return ret.Val, ret.Err
+}
+
+func (m *Map) loadItem(key string) (*expiredItem, bool) {
+	v, ok := m.Load(key)
+	if !ok {
+		return nil, false
 	}
+	return v.(*expiredItem), true
 }
 
-func (e *entry) asyncRefresh(f func() (interface{}, error), expireTime time.Duration) {
-	// do f()
-	res, err := f()
-	if err != nil {
+func (m *Map) newExpiredItem(value interface{}, expireTime time.Duration) *expiredItem {
+	return &expiredItem{
+		expiredTime: m.now() + expireTime.Nanoseconds(),
+		value:       value,
+	}
+}
+
+// tooStale reports whether item has been expired for longer than maxStaleness
+func (o ExpiredOptions) tooStale(item *expiredItem, now int64) bool {
+	return o.maxStaleness > 0 && item.expiredTime+o.maxStaleness.Nanoseconds() <= now
+}
+
+// tryRefresh refreshes the expired item asynchronously, unless it is being refreshed or all workers are busy
+func (m *Map) tryRefresh(key string, item *expiredItem, f func() (interface{}, error), expireTime time.Duration, o ExpiredOptions) {
+	if !atomic.CompareAndSwapUint32(&item.refreshing, 0, 1) {
 		return
 	}
 
-	item := expiredItem{
-		expiredTime: time.Now().UnixNano() + int64(expireTime.Nanoseconds()),
-		value:       res,
-		refreshed:   0,
+	slots := m.refreshSlots()
+	select {
+	case slots <- struct{}{}:
+		go func() {
+			defer func() { <-slots }()
+			m.refresh(key, item, f, expireTime, o)
+		}()
+	default:
+		// refresh on a later call
+		atomic.StoreUint32(&item.refreshing, 0)
+	}
+}
+
+// refresh replaces the expired item with the result of f, unless the item has been replaced or deleted
+func (m *Map) refresh(key string, stale *expiredItem, f func() (interface{}, error), expireTime time.Duration, o ExpiredOptions) {
+	ret, _ := m.refreshes.do(key, func() *Value {
+		if cur, ok := m.loadItem(key); !ok || cur != stale {
+			return &Value{}
+		}
+
+		// do f()
+		value, err := f()
+		if err != nil {
+			return &Value{Err: err}
+		}
+		m.CompareAndSwap(key, stale, m.newExpiredItem(value, expireTime))
+		return &Value{Val: value}
+	})
+
+	if ret.Err != nil {
+		if o.onRefreshError != nil {
+			o.onRefreshError(key, ret.Err)
+		}
+		// refresh again on a later call
+		atomic.StoreUint32(&stale.refreshing, 0)
 	}
-	i := interface{}(item)
-	e.storeLocked(&i)
 }
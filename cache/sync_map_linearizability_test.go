@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+
+	"goober/lincheck"
+)
+
+func TestMap_Linearizable(t *testing.T) {
+	model := lincheck.MapModel()
+	for round := 0; round < 20; round++ {
+		m := &Map{}
+		history := lincheck.Run(4, 100, func(r *lincheck.Recorder, client, i int) {
+			key := fmt.Sprint("k", (client*31+i*7)%16)
+			// few values, so compare operations succeed
+			value, old := (client+i)%3, (client*i)%3
+			switch i % 8 {
+			case 0:
+				r.Record(client, lincheck.MapInput{Op: lincheck.MapPut, Key: key, Value: value}, func() interface{} {
+					m.Store(key, value)
+					return nil
+				})
+			case 1:
+				r.Record(client, lincheck.MapInput{Op: lincheck.MapDelete, Key: key}, func() interface{} {
+					m.Delete(key)
+					return nil
+				})
+			case 2:
+				r.Record(client, lincheck.MapInput{Op: lincheck.MapSwap, Key: key, Value: value}, func() interface{} {
+					v, ok := m.Swap(key, value)
+					return lincheck.MapOutput{Value: v, Ok: ok}
+				})
+			case 3:
+				r.Record(client, lincheck.MapInput{Op: lincheck.MapLoadAndDelete, Key: key}, func() interface{} {
+					v, ok := m.LoadAndDelete(key)
+					return lincheck.MapOutput{Value: v, Ok: ok}
+				})
+			case 4:
+				r.Record(client, lincheck.MapInput{Op: lincheck.MapCompareAndSwap, Key: key, Old: old, Value: value}, func() interface{} {
+					return m.CompareAndSwap(key, old, value)
+				})
+			case 5:
+				r.Record(client, lincheck.MapInput{Op: lincheck.MapCompareAndDelete, Key: key, Old: old}, func() interface{} {
+					return m.CompareAndDelete(key, old)
+				})
+			default:
+				r.Record(client, lincheck.MapInput{Op: lincheck.MapGet, Key: key}, func() interface{} {
+					v, ok := m.Load(key)
+					return lincheck.MapOutput{Value: v, Ok: ok}
+				})
+			}
+		})
+
+		if result := lincheck.Check(model, history); !result.Ok {
+			t.Fatalf("round %d not linearizable:\n%s", round, lincheck.Format(model, result.Violation))
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"sync"
@@ -8,6 +9,8 @@ import (
 	"testing"
 	"time"
 	"unsafe"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestMap_Loader(t *testing.T) {
@@ -167,3 +170,491 @@ func TestAtomicOps(t *testing.T) {
 	end = time.Now().UnixNano()
 	t.Logf("AddUint64 test cost: %d", (end-start)/1000)
 }
+
+func TestMap_Swap(t *testing.T) {
+	m := Map{}
+
+	previous, loaded := m.Swap("k", 1)
+	assert.False(t, loaded)
+	assert.Nil(t, previous)
+
+	previous, loaded = m.Swap("k", 2)
+	assert.True(t, loaded)
+	assert.Equal(t, 1, previous)
+
+	// promoted to the read map, the fast path swaps
+	for i := 0; i < 2; i++ {
+		m.Load("missing")
+	}
+	previous, loaded = m.Swap("k", 3)
+	assert.True(t, loaded)
+	assert.Equal(t, 2, previous)
+	v, _ := m.Load("k")
+	assert.Equal(t, 3, v)
+}
+
+func TestMap_LoadAndDelete(t *testing.T) {
+	m := Map{}
+	m.Store("k", 1)
+
+	v, loaded := m.LoadAndDelete("k")
+	assert.True(t, loaded)
+	assert.Equal(t, 1, v)
+
+	v, loaded = m.LoadAndDelete("k")
+	assert.False(t, loaded)
+	assert.Nil(t, v)
+	_, ok := m.Load("k")
+	assert.False(t, ok)
+}
+
+func TestMap_CompareAndSwap(t *testing.T) {
+	m := Map{}
+
+	// no existing value, even if old is nil
+	assert.False(t, m.CompareAndSwap("k", nil, 1))
+
+	m.Store("k", 1)
+	assert.False(t, m.CompareAndSwap("k", 2, 3))
+	assert.True(t, m.CompareAndSwap("k", 1, 3))
+	v, _ := m.Load("k")
+	assert.Equal(t, 3, v)
+
+	m.Delete("k")
+	assert.False(t, m.CompareAndSwap("k", 3, 4))
+}
+
+func TestMap_CompareAndDelete(t *testing.T) {
+	m := Map{}
+	assert.False(t, m.CompareAndDelete("k", nil))
+
+	m.Store("k", 1)
+	assert.False(t, m.CompareAndDelete("k", 2))
+	assert.True(t, m.CompareAndDelete("k", 1))
+	_, ok := m.Load("k")
+	assert.False(t, ok)
+	assert.False(t, m.CompareAndDelete("k", 1))
+
+	// the entry deleted in the dirty map is loaded again
+	m.Store("d", 1)
+	assert.True(t, m.CompareAndDelete("d", 1))
+	v, err := m.Loader("d", func() (interface{}, error) { return 2, nil })
+	assert.Nil(t, err)
+	assert.Equal(t, 2, v)
+}
+
+func TestMap_Clear(t *testing.T) {
+	m := Map{}
+	m.Clear()
+
+	for i := 0; i < 10; i++ {
+		m.Store(i, i)
+	}
+	// promote some keys to the read map
+	for i := 0; i < 10; i++ {
+		m.Load(-1)
+	}
+	m.Store(10, 10)
+
+	m.Clear()
+	n := 0
+	m.Range(func(key, value interface{}) bool {
+		n++
+		return true
+	})
+	assert.Equal(t, 0, n)
+	_, ok := m.Load(10)
+	assert.False(t, ok)
+
+	m.Store(1, 1)
+	v, ok := m.Load(1)
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+}
+
+func TestMap_LoaderSingleFlight(t *testing.T) {
+	m := Map{}
+	var calls int32
+	release := make(chan struct{})
+	errLoad := fmt.Errorf("load failed")
+	f := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil, errLoad
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Loader("slow", f)
+			errs <- err
+		}()
+	}
+	eventually(t, func() bool { return atomic.LoadInt32(&calls) == 1 })
+
+	// a slow load does not block other keys
+	m.Store("k", 1)
+	v, err := m.Loader("other", func() (interface{}, error) { return 2, nil })
+	assert.Nil(t, err)
+	assert.Equal(t, 2, v)
+	v, err = m.LoaderWithExpired("expiring", func() (interface{}, error) { return 3, nil }, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, v)
+
+	close(release)
+	wg.Wait()
+	close(errs)
+	// the error is delivered to all waiters and not stored
+	for err := range errs {
+		assert.Equal(t, errLoad, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	_, ok := m.Load("slow")
+	assert.False(t, ok)
+}
+
+func TestMap_LoaderPanic(t *testing.T) {
+	m := Map{}
+	release := make(chan struct{})
+	leader := make(chan interface{})
+	go func() {
+		defer func() { leader <- recover() }()
+		m.Loader("k", func() (interface{}, error) {
+			<-release
+			panic("boom")
+		})
+	}()
+	eventually(t, func() bool {
+		m.loads.mu.Lock()
+		defer m.loads.mu.Unlock()
+		return m.loads.m["k"] != nil
+	})
+
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := m.Loader("k", func() (interface{}, error) { return 1, nil })
+			errs <- err
+		}()
+	}
+	eventually(t, func() bool {
+		m.loads.mu.Lock()
+		defer m.loads.mu.Unlock()
+		return m.loads.m["k"].dups == 3
+	})
+	close(release)
+
+	// the panic is propagated to the caller of f, the waiters get an error
+	assert.Equal(t, "boom", <-leader)
+	for i := 0; i < 3; i++ {
+		assert.True(t, errors.Is(<-errs, ErrLoadPanicked))
+	}
+	v, err := m.Loader("k", func() (interface{}, error) { return 1, nil })
+	assert.Nil(t, err)
+	assert.Equal(t, 1, v)
+}
+
+func TestMap_LoaderKeepsStoredValue(t *testing.T) {
+	m := Map{}
+	v, err := m.Loader("k", func() (interface{}, error) {
+		// stored while loading
+		m.Store("k", "stored")
+		return "loaded", nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "stored", v)
+
+	v, err = m.Loader("k", func() (interface{}, error) { return "again", nil })
+	assert.Nil(t, err)
+	assert.Equal(t, "stored", v)
+}
+
+func TestMap_LoaderWithExpiredRefreshOnce(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	m := &Map{}
+	m.SetClock(clock)
+
+	var calls int32
+	release := make(chan struct{})
+	f := func() (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			<-release
+		}
+		return atomic.LoadInt32(&calls), nil
+	}
+	_, _ = m.LoaderWithExpired("k", f, time.Minute)
+
+	// the item is found in the dirty map and refreshed once by many readers
+	clock.Advance(time.Minute)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := m.LoaderWithExpired("k", f, time.Minute)
+			assert.Nil(t, err)
+			assert.Equal(t, int32(1), v)
+		}()
+	}
+	wg.Wait()
+	close(release)
+
+	eventually(t, func() bool {
+		v, _ := m.LoaderWithExpired("k", f, time.Minute)
+		return v == int32(2)
+	})
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestMap_LoaderWithExpiredRefreshError(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	m := &Map{}
+	m.SetClock(clock)
+
+	errRefresh := fmt.Errorf("refresh failed")
+	var fail int32 = 1
+	f := func() (interface{}, error) {
+		if atomic.LoadInt32(&fail) == 1 {
+			return nil, errRefresh
+		}
+		return "refreshed", nil
+	}
+	errs := make(chan error, 10)
+	onErr := OnRefreshError(func(key string, err error) {
+		assert.Equal(t, "k", key)
+		errs <- err
+	})
+
+	m.Store("k", m.newExpiredItem("stale", time.Minute))
+	clock.Advance(time.Minute)
+	v, err := m.LoaderWithExpired("k", f, time.Minute, onErr)
+	assert.Nil(t, err)
+	assert.Equal(t, "stale", v)
+	assert.Equal(t, errRefresh, <-errs)
+
+	// the stale value is kept and refreshed again
+	atomic.StoreInt32(&fail, 0)
+	eventually(t, func() bool {
+		v, _ := m.LoaderWithExpired("k", f, time.Minute, onErr)
+		return v == "refreshed"
+	})
+	assert.Len(t, errs, 0)
+}
+
+func TestMap_LoaderWithExpiredMaxStaleness(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	m := &Map{}
+	m.SetClock(clock)
+
+	errLoad := fmt.Errorf("load failed")
+	var fail int32 = 1
+	f := func() (interface{}, error) {
+		if atomic.LoadInt32(&fail) == 1 {
+			return nil, errLoad
+		}
+		return "loaded", nil
+	}
+	m.Store("k", m.newExpiredItem("stale", time.Minute))
+
+	// within max staleness the stale value is served
+	clock.Advance(time.Minute + 10*time.Second)
+	v, err := m.LoaderWithExpired("k", f, time.Minute, MaxStaleness(30*time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, "stale", v)
+
+	// beyond it, the value is loaded synchronously and the error returned
+	clock.Advance(20 * time.Second)
+	_, err = m.LoaderWithExpired("k", f, time.Minute, MaxStaleness(30*time.Second))
+	assert.Equal(t, errLoad, err)
+
+	atomic.StoreInt32(&fail, 0)
+	v, err = m.LoaderWithExpired("k", f, time.Minute, MaxStaleness(30*time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, "loaded", v)
+}
+
+func TestMap_LoaderWithExpiredRefreshWorkers(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	m := &Map{}
+	m.SetClock(clock)
+	m.SetRefreshWorkers(2)
+
+	var running, maxRunning int32
+	release := make(chan struct{})
+	f := func() (interface{}, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		<-release
+		return "refreshed", nil
+	}
+
+	for i := 0; i < 5; i++ {
+		m.Store(fmt.Sprint(i), m.newExpiredItem("stale", time.Minute))
+	}
+	clock.Advance(time.Minute)
+	for i := 0; i < 5; i++ {
+		v, _ := m.LoaderWithExpired(fmt.Sprint(i), f, time.Minute)
+		assert.Equal(t, "stale", v)
+	}
+	eventually(t, func() bool { return atomic.LoadInt32(&running) == 2 })
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	// keys skipped while workers were busy are refreshed on later calls
+	eventually(t, func() bool {
+		for i := 0; i < 5; i++ {
+			if v, _ := m.LoaderWithExpired(fmt.Sprint(i), f, time.Minute); v != "refreshed" {
+				return false
+			}
+		}
+		return true
+	})
+	assert.Equal(t, int32(2), atomic.LoadInt32(&maxRunning))
+}
+
+func TestMap_LoaderWithExpiredRefreshAfterDelete(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	m := &Map{}
+	m.SetClock(clock)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	f := func() (interface{}, error) {
+		close(started)
+		<-release
+		defer close(done)
+		return "refreshed", nil
+	}
+	m.Store("k", m.newExpiredItem("stale", time.Minute))
+	clock.Advance(time.Minute)
+	_, _ = m.LoaderWithExpired("k", f, time.Minute)
+
+	// a refresh does not bring back a key deleted while refreshing
+	<-started
+	m.Delete("k")
+	close(release)
+	<-done
+	time.Sleep(10 * time.Millisecond)
+	_, ok := m.Load("k")
+	assert.False(t, ok)
+}
+
+func TestMap_LoaderWithExpiredLoadDuringRefresh(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	m := &Map{}
+	m.SetClock(clock)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	refresh := func() (interface{}, error) {
+		close(started)
+		<-release
+		return "refreshed", nil
+	}
+	m.Store("k", m.newExpiredItem("stale", time.Minute))
+	clock.Advance(time.Minute)
+	_, _ = m.LoaderWithExpired("k", refresh, time.Minute)
+	<-started
+	defer close(release)
+
+	// a load of the key deleted while refreshing calls its own f and stores the value
+	m.Delete("k")
+	v, err := m.LoaderWithExpired("k", func() (interface{}, error) { return "loaded", nil }, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "loaded", v)
+	v, err = m.LoaderWithExpired("k", refresh, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "loaded", v)
+}
+
+func TestMap_Len(t *testing.T) {
+	m := Map{}
+	assert.Equal(t, 0, m.Len())
+
+	m.Store("a", 1)
+	m.Store("a", 2)
+	m.LoadOrStore("b", 1)
+	m.LoadOrStore("b", 2)
+	m.Swap("c", 1)
+	assert.Equal(t, 3, m.Len())
+
+	// deleted entries in the read map are stored again
+	for i := 0; i < 3; i++ {
+		m.Load("missing")
+	}
+	m.Delete("a")
+	m.Delete("a")
+	assert.Equal(t, 2, m.Len())
+	m.Store("a", 3)
+	assert.Equal(t, 3, m.Len())
+
+	// expunged by the next dirty map
+	m.LoadAndDelete("a")
+	m.Store("d", 1)
+	assert.Equal(t, 3, m.Len())
+	m.LoadOrStore("a", 4)
+	assert.Equal(t, 4, m.Len())
+
+	assert.False(t, m.CompareAndDelete("a", 1))
+	assert.True(t, m.CompareAndDelete("a", 4))
+	assert.True(t, m.CompareAndSwap("b", 1, 5))
+	assert.Equal(t, 3, m.Len())
+
+	_, _ = m.Loader("e", func() (interface{}, error) { return 1, nil })
+	_, _ = m.LoaderWithExpired("f", func() (interface{}, error) { return 1, nil }, time.Minute)
+	assert.Equal(t, 5, m.Len())
+
+	m.Clear()
+	assert.Equal(t, 0, m.Len())
+	m.Store("a", 1)
+	assert.Equal(t, 1, m.Len())
+}
+
+func TestMap_LenConcurrent(t *testing.T) {
+	m := Map{}
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := (w*7 + i) % 64
+				switch i % 7 {
+				case 0, 1:
+					m.Store(key, i)
+				case 2:
+					m.LoadOrStore(key, i)
+				case 3:
+					m.Delete(key)
+				case 4:
+					m.LoadAndDelete(key)
+				case 5:
+					m.Swap(key, i)
+				case 6:
+					if i%700 == 6 {
+						m.Clear()
+					}
+					m.Load(key)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	n := 0
+	m.Range(func(key, value interface{}) bool {
+		n++
+		return true
+	})
+	assert.Equal(t, n, m.Len())
+}
@@ -0,0 +1,260 @@
+package cache
+
+import (
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// TieredCache is a two-tier Cache, a small in-memory LRUCache L1 in front of a larger,
+// slower L2 Cache.
+//
+// Reads fall through L1 -> L2 -> Loader, L2 hits and loaded values are promoted to L1,
+// loaded values are written to L2 as well. Entries evicted from L1 because it is full
+// can be demoted to L2.
+type TieredCache struct {
+	l1 *LRUCache
+	l2 Cache
+
+	stats tieredStats
+
+	opts TieredOptions
+}
+
+// TieredOptions options for tiered cache
+type TieredOptions struct {
+	l1Opts []Option
+
+	l1Expiration time.Duration
+	l2Expiration time.Duration
+
+	demoteOnEvict bool
+}
+
+// TieredOption ...
+type TieredOption func(options *TieredOptions)
+
+// NewTieredOptions new a tiered options
+func NewTieredOptions() TieredOptions {
+	return TieredOptions{
+		l1Opts:        nil,
+		l1Expiration:  0,
+		l2Expiration:  0,
+		demoteOnEvict: true,
+	}
+}
+
+// L1Options set options of the L1 LRUCache
+func L1Options(opts ...Option) TieredOption {
+	return func(options *TieredOptions) {
+		options.l1Opts = append(options.l1Opts, opts...)
+	}
+}
+
+// L1Expiration set expiration of entries written to L1
+func L1Expiration(d time.Duration) TieredOption {
+	return func(options *TieredOptions) {
+		options.l1Expiration = d
+	}
+}
+
+// L2Expiration set expiration of entries written to L2
+func L2Expiration(d time.Duration) TieredOption {
+	return func(options *TieredOptions) {
+		options.l2Expiration = d
+	}
+}
+
+// DemoteOnEvict set whether entries evicted from L1 because it is full are written to L2
+func DemoteOnEvict(v bool) TieredOption {
+	return func(options *TieredOptions) {
+		options.demoteOnEvict = v
+	}
+}
+
+// TieredStats statistics of a tiered cache
+type TieredStats struct {
+	L1Hits   uint64
+	L1Misses uint64
+	L2Hits   uint64
+	L2Misses uint64
+
+	// Loads number of Loader calls after missing both tiers
+	Loads      uint64
+	Promotions uint64
+	Demotions  uint64
+
+	L1Size int
+	L2Size int
+}
+
+type tieredStats struct {
+	l1Hits   *atomic.Uint64
+	l1Misses *atomic.Uint64
+	l2Hits   *atomic.Uint64
+	l2Misses *atomic.Uint64
+
+	loads      *atomic.Uint64
+	promotions *atomic.Uint64
+	demotions  *atomic.Uint64
+}
+
+// NewTieredCache new a tiered cache with l2 as the second tier
+func NewTieredCache(l2 Cache, opt ...TieredOption) *TieredCache {
+	opts := NewTieredOptions()
+	for _, o := range opt {
+		o(&opts)
+	}
+
+	t := &TieredCache{
+		l2: l2,
+		stats: tieredStats{
+			l1Hits:     atomic.NewUint64(0),
+			l1Misses:   atomic.NewUint64(0),
+			l2Hits:     atomic.NewUint64(0),
+			l2Misses:   atomic.NewUint64(0),
+			loads:      atomic.NewUint64(0),
+			promotions: atomic.NewUint64(0),
+			demotions:  atomic.NewUint64(0),
+		},
+		opts: opts,
+	}
+
+	l1Opts := []Option{DefaultEntryOpts(ExpirationOption(opts.l1Expiration))}
+	l1Opts = append(l1Opts, opts.l1Opts...)
+	if opts.demoteOnEvict {
+		l1Opts = append(l1Opts, OnEvicted(t.demote))
+	}
+	t.l1 = NewLRUCache(l1Opts...)
+
+	return t
+}
+
+func (t *TieredCache) demote(key string, value *Value) {
+	// do not demote failed loader results
+	if value == nil || value.Err != nil {
+		return
+	}
+
+	t.l2.Put(key, value.Val, ExpirationOption(t.opts.l2Expiration))
+	t.stats.demotions.Inc()
+}
+
+// Get retrieves an element from L1, then from L2.
+// L2 hits are promoted to L1.
+func (t *TieredCache) Get(key string) (*Value, bool) {
+	if v, ok := t.l1.Get(key); ok {
+		t.stats.l1Hits.Inc()
+		return v, true
+	}
+	t.stats.l1Misses.Inc()
+
+	v, ok := t.l2.Get(key)
+	if !ok {
+		t.stats.l2Misses.Inc()
+		return nil, false
+	}
+	t.stats.l2Hits.Inc()
+
+	if v.Err == nil {
+		t.l1.Put(key, v.Val, ExpirationOption(t.opts.l1Expiration))
+		t.stats.promotions.Inc()
+	}
+
+	return v, true
+}
+
+// Put adds an element to both tiers, returning the previous element of L1
+func (t *TieredCache) Put(key string, value interface{}, opts ...EntryOption) interface{} {
+	t.l2.Put(key, value, t.l2EntryOpts(opts)...)
+	return t.l1.Put(key, value, t.l1EntryOpts(opts)...)
+}
+
+// Delete deletes an element in both tiers
+func (t *TieredCache) Delete(key string) {
+	t.l1.Delete(key)
+	t.l2.Delete(key)
+}
+
+// Size returns the number of entries currently stored in L1
+func (t *TieredCache) Size() int {
+	return t.l1.Size()
+}
+
+// Shed evicts at most n entries of L1, which are demoted to L2 if DemoteOnEvict is set
+func (t *TieredCache) Shed(n int) int {
+	return t.l1.Shed(n)
+}
+
+// CompareAndSwap compares and swaps the element in L1, the swapped element is written to L2 as well
+func (t *TieredCache) CompareAndSwap(key string, old, new interface{}, opts ...EntryOption) (interface{}, bool) {
+	v, swapped := t.l1.CompareAndSwap(key, old, new, t.l1EntryOpts(opts)...)
+	if swapped {
+		t.l2.Put(key, new, t.l2EntryOpts(opts)...)
+	}
+
+	return v, swapped
+}
+
+// Load loads the element through L1, then L2, finally the Loader of opts.
+// Loaded values are written to both tiers.
+func (t *TieredCache) Load(cacheKey string, opts ...EntryOption) (*Value, bool) {
+	eOpts := t.l1.opts.defaultEntryOpts
+	for _, o := range opts {
+		o(&eOpts)
+	}
+
+	// loader function is nil, then return Get(key) innerValue
+	if eOpts.loader == nil {
+		return t.Get(cacheKey)
+	}
+
+	loader := eOpts.loader
+	l1Opts := append(t.l1EntryOpts(opts), WithLoader(func(key string) *Value {
+		if v, ok := t.l2.Get(key); ok {
+			t.stats.l2Hits.Inc()
+			t.stats.promotions.Inc()
+			return v
+		}
+		t.stats.l2Misses.Inc()
+
+		v := loader(key)
+		t.stats.loads.Inc()
+		if v.Err == nil {
+			t.l2.Put(key, v.Val, t.l2EntryOpts(opts)...)
+		}
+		return v
+	}))
+
+	v, loaded := t.l1.Load(cacheKey, l1Opts...)
+	if loaded {
+		t.stats.l1Hits.Inc()
+	} else {
+		t.stats.l1Misses.Inc()
+	}
+
+	return v, loaded
+}
+
+func (t *TieredCache) l1EntryOpts(opts []EntryOption) []EntryOption {
+	return append([]EntryOption{ExpirationOption(t.opts.l1Expiration)}, opts...)
+}
+
+func (t *TieredCache) l2EntryOpts(opts []EntryOption) []EntryOption {
+	return append([]EntryOption{ExpirationOption(t.opts.l2Expiration)}, opts...)
+}
+
+// Stats returns statistics of the tiered cache
+func (t *TieredCache) Stats() TieredStats {
+	return TieredStats{
+		L1Hits:     t.stats.l1Hits.Load(),
+		L1Misses:   t.stats.l1Misses.Load(),
+		L2Hits:     t.stats.l2Hits.Load(),
+		L2Misses:   t.stats.l2Misses.Load(),
+		Loads:      t.stats.loads.Load(),
+		Promotions: t.stats.promotions.Load(),
+		Demotions:  t.stats.demotions.Load(),
+		L1Size:     t.l1.Size(),
+		L2Size:     t.l2.Size(),
+	}
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTieredCache_LoadFallThrough(t *testing.T) {
+	l2 := NewLRUCache()
+	var c Cache = NewTieredCache(l2, L1Expiration(time.Minute), L2Expiration(time.Hour))
+	tc := c.(*TieredCache)
+
+	calls := 0
+	loader := WithLoader(func(key string) *Value {
+		calls++
+		return &Value{Val: "origin_" + key}
+	})
+
+	// miss both tiers, call loader and write to both
+	v, loaded := c.Load("a", loader)
+	assert.False(t, loaded)
+	assert.Equal(t, "origin_a", v.Val)
+	assert.Equal(t, 1, calls)
+	v, ok := l2.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "origin_a", v.Val)
+
+	// hit L1
+	v, loaded = c.Load("a", loader)
+	assert.True(t, loaded)
+	assert.Equal(t, "origin_a", v.Val)
+	assert.Equal(t, 1, calls)
+
+	// hit L2 and promote
+	l2.Put("b", "l2_b")
+	v, loaded = c.Load("b", loader)
+	assert.False(t, loaded)
+	assert.Equal(t, "l2_b", v.Val)
+	assert.Equal(t, 1, calls)
+	_, ok = tc.l1.Get("b")
+	assert.True(t, ok)
+
+	stats := tc.Stats()
+	assert.Equal(t, uint64(1), stats.L1Hits)
+	assert.Equal(t, uint64(2), stats.L1Misses)
+	assert.Equal(t, uint64(1), stats.L2Hits)
+	assert.Equal(t, uint64(1), stats.L2Misses)
+	assert.Equal(t, uint64(1), stats.Loads)
+	assert.Equal(t, uint64(1), stats.Promotions)
+}
+
+func TestTieredCache_GetPromote(t *testing.T) {
+	l2 := NewLRUCache()
+	tc := NewTieredCache(l2)
+
+	_, ok := tc.Get("a")
+	assert.False(t, ok)
+
+	l2.Put("a", 1)
+	v, ok := tc.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v.Val)
+	assert.Equal(t, 1, tc.Size())
+
+	tc.Delete("a")
+	_, ok = tc.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, l2.Size())
+}
+
+func TestTieredCache_Demote(t *testing.T) {
+	l2 := NewLRUCache()
+	tc := NewTieredCache(l2, L1Options(MaxSize(4), CleanSize(2)))
+
+	for i := 0; i < 4; i++ {
+		tc.l1.Put(strconv.Itoa(i), i)
+	}
+	// l1 is full, the two least recently used entries are demoted
+	tc.l1.Put("4", 4)
+
+	assert.Equal(t, 3, tc.Size())
+	assert.Equal(t, uint64(2), tc.Stats().Demotions)
+	for i := 0; i < 2; i++ {
+		v, ok := l2.Get(strconv.Itoa(i))
+		assert.True(t, ok)
+		assert.Equal(t, i, v.Val)
+	}
+
+	v, ok := tc.Get("0")
+	assert.True(t, ok)
+	assert.Equal(t, 0, v.Val)
+}
@@ -0,0 +1,247 @@
+package v2
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	defaultReplicas = 100
+)
+
+// NodeHash maps keys to nodes, it is implemented by ConsistentHash, JumpHash and RendezvousHash
+type NodeHash interface {
+	// Add adds a node, a node of weight 2 receives about twice the keys of weight 1.
+	// Adding an existing node updates its weight.
+	Add(node string, weight int)
+	// Remove removes a node
+	Remove(node string)
+	// Get returns the node of key, false if there is no node
+	Get(key string) (string, bool)
+	// GetN returns at most n distinct nodes of key, the first one is Get(key)
+	GetN(key string, n int) []string
+	// Nodes returns all nodes
+	Nodes() []string
+}
+
+// ConsistentHash is a consistent hashing ring with weighted virtual nodes,
+// adding or removing a node only moves the keys of its virtual nodes.
+//
+// With bounded loads, Acquire assigns a key to the first node clockwise whose load
+// is below ceil(loadFactor * average load), see "Consistent Hashing with Bounded Loads".
+type ConsistentHash struct {
+	mu sync.RWMutex
+
+	replicas int
+	weights  map[string]int
+
+	// sorted hashes of virtual nodes
+	hashes []uint64
+	vNodes map[uint64]string
+
+	loadFactor float64
+	loads      map[string]int64
+	totalLoad  int64
+}
+
+// NewConsistentHash new a consistent hash, each node has replicas * weight virtual nodes
+func NewConsistentHash(replicas int) *ConsistentHash {
+	if replicas <= 0 {
+		replicas = defaultReplicas
+	}
+	return &ConsistentHash{
+		replicas: replicas,
+		weights:  make(map[string]int),
+		vNodes:   make(map[uint64]string),
+		loads:    make(map[string]int64),
+	}
+}
+
+// NewBoundedConsistentHash new a consistent hash with bounded loads, loadFactor must be larger than 1
+func NewBoundedConsistentHash(replicas int, loadFactor float64) *ConsistentHash {
+	c := NewConsistentHash(replicas)
+	c.loadFactor = loadFactor
+	return c
+}
+
+func vNodeHash(node string, i int) uint64 {
+	return strHash(node + string(SeparatorByte) + strconv.Itoa(i))
+}
+
+// Add ...
+func (c *ConsistentHash) Add(node string, weight int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.weights[node]; ok {
+		c.removeLocked(node)
+	}
+	if weight <= 0 {
+		return
+	}
+
+	c.weights[node] = weight
+	for i := 0; i < c.replicas*weight; i++ {
+		h := vNodeHash(node, i)
+		// hash conflict of virtual nodes, the first one wins
+		if _, ok := c.vNodes[h]; ok {
+			continue
+		}
+		c.vNodes[h] = node
+		c.hashes = append(c.hashes, h)
+	}
+	sort.Slice(c.hashes, func(i, j int) bool { return c.hashes[i] < c.hashes[j] })
+}
+
+// Remove ...
+func (c *ConsistentHash) Remove(node string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeLocked(node)
+}
+
+func (c *ConsistentHash) removeLocked(node string) {
+	if _, ok := c.weights[node]; !ok {
+		return
+	}
+	delete(c.weights, node)
+
+	hashes := c.hashes[:0]
+	for _, h := range c.hashes {
+		if c.vNodes[h] == node {
+			delete(c.vNodes, h)
+			continue
+		}
+		hashes = append(hashes, h)
+	}
+	c.hashes = hashes
+
+	c.totalLoad -= c.loads[node]
+	delete(c.loads, node)
+}
+
+// search returns the index of the first virtual node clockwise of key
+func (c *ConsistentHash) search(key string) int {
+	h := strHash(key)
+	i := sort.Search(len(c.hashes), func(i int) bool { return c.hashes[i] >= h })
+	if i == len(c.hashes) {
+		i = 0
+	}
+	return i
+}
+
+// Get ...
+func (c *ConsistentHash) Get(key string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.hashes) == 0 {
+		return "", false
+	}
+	return c.vNodes[c.hashes[c.search(key)]], true
+}
+
+// GetN ...
+func (c *ConsistentHash) GetN(key string, n int) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.hashes) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(c.weights) {
+		n = len(c.weights)
+	}
+
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for i, start := 0, c.search(key); len(nodes) < n; i++ {
+		node := c.vNodes[c.hashes[(start+i)%len(c.hashes)]]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// Nodes ...
+func (c *ConsistentHash) Nodes() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return sortedNodes(c.weights)
+}
+
+// maxLoadLocked returns the max load of node with bounded loads
+func (c *ConsistentHash) maxLoadLocked(node string) int64 {
+	totalWeight := 0
+	for _, w := range c.weights {
+		totalWeight += w
+	}
+	avg := float64(c.totalLoad+1) / float64(totalWeight)
+	return int64(math.Ceil(avg * c.loadFactor * float64(c.weights[node])))
+}
+
+// Acquire returns the node of key with bounded loads and increases its load by one,
+// the load should be released by Release after the key is done.
+// Without bounded loads it is Get plus load counting.
+func (c *ConsistentHash) Acquire(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.hashes) == 0 {
+		return "", false
+	}
+
+	start := c.search(key)
+	node := c.vNodes[c.hashes[start]]
+	if c.loadFactor > 0 {
+		for i := 0; i < len(c.hashes); i++ {
+			n := c.vNodes[c.hashes[(start+i)%len(c.hashes)]]
+			if c.loads[n] < c.maxLoadLocked(n) {
+				node = n
+				break
+			}
+		}
+	}
+
+	c.loads[node]++
+	c.totalLoad++
+	return node, true
+}
+
+// Release decreases the load of node by one
+func (c *ConsistentHash) Release(node string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.loads[node] > 0 {
+		c.loads[node]--
+		c.totalLoad--
+	}
+}
+
+// Loads returns the load of each node
+func (c *ConsistentHash) Loads() map[string]int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	loads := make(map[string]int64, len(c.weights))
+	for node := range c.weights {
+		loads[node] = c.loads[node]
+	}
+	return loads
+}
+
+func sortedNodes(weights map[string]int) []string {
+	nodes := make([]string, 0, len(weights))
+	for node := range weights {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
@@ -29,5 +29,9 @@ func strHash(key string) uint64 {
 
 const (
 	reHashThreshold = 0.75
-	initBPower      = 3
+	// shrinkThreshold halves buckets when the load factor drops below it
+	shrinkThreshold = 0.25
+	// transferStride number of old buckets migrated by an operation while resizing
+	transferStride = 4
+	initBPower     = 3
 )
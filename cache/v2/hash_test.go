@@ -0,0 +1,120 @@
+package v2
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func nodeHashes() map[string]NodeHash {
+	return map[string]NodeHash{
+		"consistent": NewConsistentHash(100),
+		"jump":       NewJumpHash(),
+		"rendezvous": NewRendezvousHash(),
+	}
+}
+
+func TestNodeHash_Distribution(t *testing.T) {
+	for name, h := range nodeHashes() {
+		h.Add("a", 1)
+		h.Add("b", 1)
+		h.Add("c", 2)
+
+		counts := map[string]int{}
+		keys := 40000
+		for i := 0; i < keys; i++ {
+			node, ok := h.Get(strconv.Itoa(i))
+			assert.True(t, ok)
+			counts[node]++
+		}
+
+		// weight 2 node receives about half of the keys
+		assert.InDelta(t, 0.5, float64(counts["c"])/float64(keys), 0.08, name)
+		assert.InDelta(t, 0.25, float64(counts["a"])/float64(keys), 0.08, name)
+		assert.Equal(t, []string{"a", "b", "c"}, h.Nodes(), name)
+	}
+}
+
+func TestNodeHash_MinimalMovement(t *testing.T) {
+	for name, h := range nodeHashes() {
+		for i := 0; i < 4; i++ {
+			h.Add("node"+strconv.Itoa(i), 1)
+		}
+
+		keys := 20000
+		before := make([]string, keys)
+		for i := range before {
+			before[i], _ = h.Get(strconv.Itoa(i))
+		}
+
+		h.Add("node4", 1)
+		moved := 0
+		for i := range before {
+			node, _ := h.Get(strconv.Itoa(i))
+			if node != before[i] {
+				// keys only move to the new node
+				assert.Equal(t, "node4", node, name)
+				moved++
+			}
+		}
+		assert.InDelta(t, 0.2, float64(moved)/float64(keys), 0.06, name)
+
+		h.Remove("node4")
+		for i := range before {
+			node, _ := h.Get(strconv.Itoa(i))
+			assert.Equal(t, before[i], node, name)
+		}
+	}
+}
+
+func TestNodeHash_GetN(t *testing.T) {
+	for name, h := range nodeHashes() {
+		assert.Nil(t, h.GetN("k", 2), name)
+		_, ok := h.Get("k")
+		assert.False(t, ok, name)
+
+		for i := 0; i < 5; i++ {
+			h.Add("node"+strconv.Itoa(i), 1)
+		}
+		for i := 0; i < 100; i++ {
+			key := strconv.Itoa(i)
+			nodes := h.GetN(key, 3)
+			assert.Len(t, nodes, 3, name)
+			first, _ := h.Get(key)
+			assert.Equal(t, first, nodes[0], name)
+			assert.NotEqual(t, nodes[0], nodes[1], name)
+			assert.NotEqual(t, nodes[1], nodes[2], name)
+			assert.NotEqual(t, nodes[0], nodes[2], name)
+		}
+		assert.Len(t, h.GetN("k", 10), 5, name)
+	}
+}
+
+func TestConsistentHash_BoundedLoads(t *testing.T) {
+	c := NewBoundedConsistentHash(100, 1.25)
+	for i := 0; i < 4; i++ {
+		c.Add("node"+strconv.Itoa(i), 1)
+	}
+
+	// the same hot key is spread once its node is full
+	for i := 0; i < 100; i++ {
+		_, ok := c.Acquire("hot")
+		assert.True(t, ok)
+	}
+	for _, load := range c.Loads() {
+		assert.True(t, load <= 32, load)
+	}
+
+	node, _ := c.Get("hot")
+	c.Release(node)
+	assert.Equal(t, int64(99), sumLoads(c.Loads()))
+}
+
+func sumLoads(loads map[string]int64) int64 {
+	var sum int64
+	for _, l := range loads {
+		sum += l
+	}
+	return sum
+}
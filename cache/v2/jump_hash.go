@@ -0,0 +1,124 @@
+package v2
+
+import (
+	"strconv"
+	"sync"
+)
+
+// JumpHash is Lamping and Veach's jump consistent hash, it needs no memory besides
+// the node list and spreads keys evenly.
+//
+// Nodes are numbered in the order they are added, a node of weight w takes w numbers.
+// Adding a node or removing the last added node moves the minimal keys, removing
+// another node renumbers the nodes after it and moves their keys as well.
+type JumpHash struct {
+	mu sync.RWMutex
+
+	weights map[string]int
+	slots   []string
+}
+
+// NewJumpHash new a jump hash
+func NewJumpHash() *JumpHash {
+	return &JumpHash{weights: make(map[string]int)}
+}
+
+// jumpHash returns the bucket in [0, buckets) of key
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// Add ...
+func (j *JumpHash) Add(node string, weight int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.weights[node]; ok {
+		j.removeLocked(node)
+	}
+	if weight <= 0 {
+		return
+	}
+
+	j.weights[node] = weight
+	for i := 0; i < weight; i++ {
+		j.slots = append(j.slots, node)
+	}
+}
+
+// Remove ...
+func (j *JumpHash) Remove(node string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.removeLocked(node)
+}
+
+func (j *JumpHash) removeLocked(node string) {
+	if _, ok := j.weights[node]; !ok {
+		return
+	}
+	delete(j.weights, node)
+
+	slots := j.slots[:0]
+	for _, n := range j.slots {
+		if n != node {
+			slots = append(slots, n)
+		}
+	}
+	j.slots = slots
+}
+
+// Get ...
+func (j *JumpHash) Get(key string) (string, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	if len(j.slots) == 0 {
+		return "", false
+	}
+	return j.slots[jumpHash(strHash(key), len(j.slots))], true
+}
+
+// GetN ...
+func (j *JumpHash) GetN(key string, n int) []string {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	if len(j.slots) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(j.weights) {
+		n = len(j.weights)
+	}
+
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	// rehash key until enough distinct nodes are found
+	for i := 0; len(nodes) < n; i++ {
+		h := strHash(key)
+		if i > 0 {
+			h = strHash(key + string(SeparatorByte) + strconv.Itoa(i))
+		}
+		node := j.slots[jumpHash(h, len(j.slots))]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// Nodes ...
+func (j *JumpHash) Nodes() []string {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return sortedNodes(j.weights)
+}
@@ -0,0 +1,68 @@
+package v2
+
+import (
+	"fmt"
+	"testing"
+
+	"goober/lincheck"
+)
+
+const (
+	linRounds  = 20
+	linClients = 4
+	linOps     = 100
+	linKeys    = 64
+)
+
+type linMap interface {
+	Get(key string) (interface{}, bool)
+	Put(key string, value interface{}) bool
+}
+
+// linDeleter a linMap supporting deletes
+type linDeleter interface {
+	LoadAndDelete(key string) (interface{}, bool)
+}
+
+func checkMapLinearizable(t *testing.T, newMap func() linMap) {
+	model := lincheck.MapModel()
+	for round := 0; round < linRounds; round++ {
+		m := newMap()
+		d, canDelete := m.(linDeleter)
+		history := lincheck.Run(linClients, linOps, func(r *lincheck.Recorder, client, i int) {
+			// grow the map while keys are read and written to cover rehashing
+			key := fmt.Sprint("k", (client*31+i*7)%linKeys)
+			if i%3 == 0 {
+				value := client*linOps + i
+				r.Record(client, lincheck.MapInput{Op: lincheck.MapPut, Key: key, Value: value}, func() interface{} {
+					m.Put(key, value)
+					return nil
+				})
+				return
+			}
+			if canDelete && i%5 == 1 {
+				r.Record(client, lincheck.MapInput{Op: lincheck.MapLoadAndDelete, Key: key}, func() interface{} {
+					v, ok := d.LoadAndDelete(key)
+					return lincheck.MapOutput{Value: v, Ok: ok}
+				})
+				return
+			}
+			r.Record(client, lincheck.MapInput{Op: lincheck.MapGet, Key: key}, func() interface{} {
+				v, ok := m.Get(key)
+				return lincheck.MapOutput{Value: v, Ok: ok}
+			})
+		})
+
+		if result := lincheck.Check(model, history); !result.Ok {
+			t.Fatalf("round %d not linearizable:\n%s", round, lincheck.Format(model, result.Violation))
+		}
+	}
+}
+
+func TestMap_Linearizable(t *testing.T) {
+	checkMapLinearizable(t, func() linMap { return NewLockFreeMap() })
+}
+
+func TestRedBlackMap_Linearizable(t *testing.T) {
+	checkMapLinearizable(t, func() linMap { return NewRedBlackMap() })
+}
@@ -7,141 +7,232 @@ import (
 
 // bucket node
 type node struct {
-	key   interface{}
+	key interface{}
+	// state *nodeState, replaced by cas
+	state unsafe.Pointer
+}
+
+// nodeState an immutable state of a node.
+//
+// deleted marks the node as logically deleted like the marked next pointer of a Harris list:
+// a deleted state is never replaced, so nothing is linked after a node being unlinked.
+type nodeState struct {
 	value interface{}
 	next  *node
+
+	deleted bool
+
+	// absent means the key is deleted while rehashing, the node is kept so the key
+	// is not looked up in old buckets, see Map.LoadAndDelete
+	absent bool
 }
 
 // return a new node
 func newNode(key, value interface{}) *node {
 	return &node{
 		key:   key,
-		value: value,
-		next:  nil,
+		state: unsafe.Pointer(&nodeState{value: value}),
 	}
 }
 
+func (n *node) load() *nodeState {
+	return (*nodeState)(atomic.LoadPointer(&n.state))
+}
+
+func (n *node) cas(old, new *nodeState) bool {
+	return atomic.CompareAndSwapPointer(&n.state, unsafe.Pointer(old), unsafe.Pointer(new))
+}
+
 // lock-free list
 type bucket struct {
 	head unsafe.Pointer
-	tail unsafe.Pointer
+
+	// forwarded marks an old bucket migrated to new buckets, see Map.transfer
+	forwarded uint32
 }
 
 // return a new bucket
 func newBucket() *bucket {
 	return &bucket{
 		head: nil,
-		tail: nil,
 	}
 }
 
-// find value of key
-func (b *bucket) find(key interface{}) (interface{}, bool) {
-	v := atomic.LoadPointer(&b.head)
-	if v == nil {
-		return nil, false
-	}
+// forward marks the bucket migrated
+func (b *bucket) forward() {
+	atomic.StoreUint32(&b.forwarded, 1)
+}
 
-	tmp := (*node)(v)
-	for tmp != nil {
-		if tmp.key == key {
-			return tmp.value, true
-		}
+// isForwarded reports whether the bucket is migrated, then lookups go to new buckets
+func (b *bucket) isForwarded() bool {
+	return atomic.LoadUint32(&b.forwarded) == 1
+}
 
-		tmp = tmp.next
+// lookup returns the state of the live node of key, which may be absent
+func (b *bucket) lookup(key interface{}) (*nodeState, bool) {
+	n := (*node)(atomic.LoadPointer(&b.head))
+	for n != nil {
+		st := n.load()
+		if !st.deleted && n.key == key {
+			return st, true
+		}
+		n = st.next
 	}
-
 	return nil, false
 }
 
 // Get return value of key.
 // if not found return false
 func (b *bucket) Get(key interface{}) (interface{}, bool) {
-	return b.find(key)
+	st, ok := b.lookup(key)
+	if !ok || st.absent {
+		return nil, false
+	}
+	return st.value, true
 }
 
-//// Put set key and value to bucket
-//func (b *bucket) Put(key, value interface{}) {
-//	headAddr := &b.head
-//	tailAddr := &b.tail
-//
-//	tmpPre := atomic.LoadPointer(tailAddr)
-//	tmpNewTail := &node{
-//		key:   key,
-//		value: value,
-//		next:  nil,
-//	}
-//
-//	// cas
-//	for {
-//		tmpPre = atomic.LoadPointer(tailAddr)
-//		if tmpPre == nil {
-//			if atomic.CompareAndSwapPointer(headAddr, nil, unsafe.Pointer(tmpNewTail)) {
-//				atomic.StorePointer(tailAddr, unsafe.Pointer(tmpNewTail))
-//				return
-//			}
-//			continue
-//		}
-//
-//		preNode := (*node)(tmpPre)
-//		if !atomic.CompareAndSwapPointer((*unsafe.Pointer)(unsafe.Pointer(&preNode.next)),
-//			nil, unsafe.Pointer(tmpNewTail)) {
-//			continue
-//		}
-//
-//		atomic.StorePointer(tailAddr, unsafe.Pointer(tmpNewTail))
-//		return
-//	}
-//}
+// unlink removes the deleted node cur following prev, or the head if prev is nil
+func (b *bucket) unlink(prev *node, prevSt *nodeState, cur *node, next *node) bool {
+	if prev == nil {
+		return atomic.CompareAndSwapPointer(&b.head, unsafe.Pointer(cur), unsafe.Pointer(next))
+	}
+	return prev.cas(prevSt, &nodeState{value: prevSt.value, next: next, absent: prevSt.absent})
+}
 
-// Put set key and value to bucket if key exist then recover
-func (b *bucket) Put(key, value interface{}) {
-	headAddr := &b.head
-	tmpPre := atomic.LoadPointer(headAddr)
-	tmpNewTail := &node{
-		key:   key,
-		value: value,
-		next:  nil,
+// search walks the list unlinking deleted nodes, returning the live node of key and its state,
+// or the last node and its state if key is not found. ok is false if the walk must be retried.
+func (b *bucket) search(key interface{}) (n *node, st *nodeState, found, ok bool) {
+	var (
+		prev   *node
+		prevSt *nodeState
+	)
+	cur := (*node)(atomic.LoadPointer(&b.head))
+	for cur != nil {
+		curSt := cur.load()
+		if curSt.deleted {
+			if !b.unlink(prev, prevSt, cur, curSt.next) {
+				return nil, nil, false, false
+			}
+			if prev != nil {
+				prevSt = prev.load()
+				if prevSt.deleted {
+					return nil, nil, false, false
+				}
+			}
+			cur = curSt.next
+			continue
+		}
+		if cur.key == key {
+			return cur, curSt, true, true
+		}
+		prev, prevSt = cur, curSt
+		cur = curSt.next
 	}
+	return prev, prevSt, false, true
+}
 
+// swap sets the value of key, absent if absent is true, returning the previous state if the key existed
+func (b *bucket) swap(key, value interface{}, absent bool) (*nodeState, bool) {
 	for {
-		// 1. if head nil then cas
-		tmpPre = atomic.LoadPointer(headAddr)
-		if tmpPre == nil {
-			if atomic.CompareAndSwapPointer(headAddr, nil, unsafe.Pointer(tmpNewTail)) {
-				return
+		n, st, found, ok := b.search(key)
+		if !ok {
+			continue
+		}
+
+		// replace the value
+		if found {
+			if n.cas(st, &nodeState{value: value, next: st.next, absent: absent}) {
+				return st, true
 			}
 			continue
 		}
 
-		curNode := (*node)(tmpPre)
-		preNode := curNode
-		for {
-			// 2. find key
-			for curNode != nil {
-				if curNode.key == key {
-					curNode.value = value // recover
-					return
-				}
-				preNode = curNode
-				curNode = curNode.next
+		if b.append(n, st, key, value, absent) {
+			return nil, false
+		}
+	}
+}
+
+// loadOrStore stores value if key has no live node, returning the state of the node otherwise,
+// which may be absent
+func (b *bucket) loadOrStore(key, value interface{}) (*nodeState, bool) {
+	for {
+		n, st, found, ok := b.search(key)
+		if !ok {
+			continue
+		}
+		if found {
+			return st, true
+		}
+		if b.append(n, st, key, value, false) {
+			return nil, false
+		}
+	}
+}
+
+// append links a new node after the last node n, or as the head if n is nil.
+// A node of key appended meanwhile fails it and is found by the next search.
+func (b *bucket) append(n *node, st *nodeState, key, value interface{}, absent bool) bool {
+	newN := newNode(key, value)
+	newN.load().absent = absent
+	if n == nil {
+		return atomic.CompareAndSwapPointer(&b.head, nil, unsafe.Pointer(newN))
+	}
+	return n.cas(st, &nodeState{value: st.value, next: newN, absent: st.absent})
+}
+
+// Put set key and value to bucket if key exist then recover
+func (b *bucket) Put(key, value interface{}) {
+	b.swap(key, value, false)
+}
+
+// LoadAndDelete deletes key, returning its value if it existed and was not absent.
+// The node is marked deleted first, then unlinked by this or a later walk.
+func (b *bucket) LoadAndDelete(key interface{}) (interface{}, bool) {
+	for {
+		n, st, found, ok := b.search(key)
+		if !ok {
+			continue
+		}
+		if !found {
+			return nil, false
+		}
+		if n.cas(st, &nodeState{value: st.value, next: st.next, deleted: true, absent: st.absent}) {
+			// unlink it
+			b.search(key)
+			if st.absent {
+				return nil, false
 			}
-			// 3. CAS preNode.next
-			if !atomic.CompareAndSwapPointer((*unsafe.Pointer)(unsafe.Pointer(&preNode.next)),
-				nil, unsafe.Pointer(tmpNewTail)) {
-				// 4. if CAS fail then reset curNode
-				curNode = preNode.next
+			return st.value, true
+		}
+	}
+}
+
+// dropAbsent deletes the absent nodes of the keys drop returns true for.
+// A node set live meanwhile fails the cas and is kept.
+func (b *bucket) dropAbsent(drop func(key interface{}) bool) {
+	n := (*node)(atomic.LoadPointer(&b.head))
+	for n != nil {
+		st := n.load()
+		if st.absent && !st.deleted && drop(n.key) {
+			if !n.cas(st, &nodeState{value: st.value, next: st.next, deleted: true, absent: true}) {
 				continue
 			}
-			return
+			// unlink it
+			b.search(n.key)
 		}
+		n = st.next
 	}
 }
 
+// rangeBucket calls f with the live keys and values
 func (b *bucket) rangeBucket(f func(key, value interface{})) {
 	n := (*node)(atomic.LoadPointer(&b.head))
 	for n != nil {
-		f(n.key, n.value)
-		n = n.next
+		st := n.load()
+		if !st.deleted && !st.absent {
+			f(n.key, st.value)
+		}
+		n = st.next
 	}
 }
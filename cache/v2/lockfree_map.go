@@ -11,30 +11,37 @@ type bucketPackage struct {
 	oldBuckets []*bucket
 }
 
+// Map resizes by migrating old buckets to new buckets incrementally: operations touching the map
+// while resizing help transfer a stride of old buckets, like ConcurrentHashMap of Java.
+// Puts and deletes write new buckets only, so old buckets are read-only while migrated.
 type Map struct {
-	cap   *atomic.Uint64
-	count *atomic.Uint64
+	cap *atomic.Uint64
+	// count of keys, updated after the buckets so it may be transiently negative
+	count *atomic.Int64
 
 	//buckets    []*bucket
 	//oldBuckets []*bucket
 	bkPkg *atomic.Value
 
-	growing      *atomic.Bool
-	growingIndex *atomic.Int64
+	// transferIndex next old bucket to migrate, -1 if not resizing
+	transferIndex *atomic.Int64
+	// transferred number of old buckets migrated
+	transferred *atomic.Int64
 
+	// the lock protects replacing buckets, operations and transfer hold the read lock
 	sync.RWMutex
 }
 
 func NewLockFreeMap() *Map {
 	m := &Map{
 		cap:   atomic.NewUint64(uint64(math.Pow(2, initBPower))),
-		count: atomic.NewUint64(0),
+		count: atomic.NewInt64(0),
 
 		//buckets: nil,
 		bkPkg: &atomic.Value{},
 
-		growing:      atomic.NewBool(false),
-		growingIndex: atomic.NewInt64(-1),
+		transferIndex: atomic.NewInt64(-1),
+		transferred:   atomic.NewInt64(0),
 	}
 
 	bs := m.cap.Load()
@@ -48,40 +55,52 @@ func NewLockFreeMap() *Map {
 }
 
 func (m *Map) Get(key string) (interface{}, bool) {
+	m.helpTransfer()
+
 	m.RLock()
 	defer m.RUnlock()
 
 	bkPkg := m.bkPkg.Load().(*bucketPackage)
-	oldBs := bkPkg.oldBuckets
-	newBs := bkPkg.buckets
+	keyHash := strHash(key)
+	b := bucketOf(keyHash, bkPkg.buckets)
+	oldB := bucketOf(keyHash, bkPkg.oldBuckets)
 
-	v, ok := m.getFormBuckets(key, newBs)
-	if ok {
-		return v, ok
+	// a forwarded old bucket is copied, checked before new buckets so the copy is seen
+	if oldB == nil || oldB.isForwarded() {
+		return b.Get(key)
 	}
-	return m.getFormBuckets(key, oldBs)
+
+	// a key deleted while rehashing is absent in new buckets, and not read from old buckets
+	if st, ok := b.lookup(key); ok {
+		if st.absent {
+			return nil, false
+		}
+		return st.value, true
+	}
+	return oldB.Get(key)
 }
 
-func (m *Map) getFormBuckets(key string, buckets []*bucket) (interface{}, bool) {
+// bucketOf returns the bucket of keyHash, nil if buckets is empty
+func bucketOf(keyHash uint64, buckets []*bucket) *bucket {
 	if len(buckets) == 0 {
-		return nil, false
+		return nil
 	}
-	keyHash := strHash(key)
-	i := keyHash % uint64(len(buckets))
-	b := buckets[i]
-
-	return b.Get(key)
+	return buckets[keyHash%uint64(len(buckets))]
 }
 
-func (m *Map) Put(key string, value interface{}) {
-	m.put(key, value)
-	m.count.Inc()
-	if m.checkReHashThreshold() {
-		go m.doGrowWork()
+// Put sets the value of key, returning true if key is inserted, false if its value is replaced
+func (m *Map) Put(key string, value interface{}) bool {
+	m.helpTransfer()
+
+	inserted := m.put(key, value)
+	if inserted {
+		m.count.Inc()
+		m.checkReHashThreshold()
 	}
+	return inserted
 }
 
-func (m *Map) put(key string, value interface{}) {
+func (m *Map) put(key string, value interface{}) bool {
 	// need a lock
 	// Make sure you get new buckets or will write to old bucket!!!
 	// old bucket must be not written because of copying
@@ -89,94 +108,193 @@ func (m *Map) put(key string, value interface{}) {
 	defer m.RUnlock()
 
 	bkPkg := m.bkPkg.Load().(*bucketPackage)
-	newBs := bkPkg.buckets
-
 	keyHash := strHash(key)
-	i := keyHash % uint64(len(newBs))
-	b := newBs[i]
+	b := bucketOf(keyHash, bkPkg.buckets)
+	oldB := bucketOf(keyHash, bkPkg.oldBuckets)
+	forwarded := oldB == nil || oldB.isForwarded()
 
-	b.Put(key, value)
+	pre, ok := b.swap(key, value, false)
+	if ok {
+		return pre.absent
+	}
+	// the key may be not copied from old buckets yet
+	if forwarded {
+		return true
+	}
+	_, ok = oldB.Get(key)
+	return !ok
 }
 
-func (m *Map) checkReHashThreshold() bool {
-	if m.growingIndex.Load() >= 0 {
-		return true
+// Len returns the number of keys
+func (m *Map) Len() int {
+	if n := m.count.Load(); n > 0 {
+		return int(n)
 	}
+	return 0
+}
 
-	m.Lock()
-	defer m.Unlock()
+// Delete deletes the value of key
+func (m *Map) Delete(key string) {
+	m.LoadAndDelete(key)
+}
 
-	if m.growingIndex.Load() >= 0 {
-		return true
+// LoadAndDelete deletes the value of key, returning the previous value if any
+func (m *Map) LoadAndDelete(key string) (interface{}, bool) {
+	m.helpTransfer()
+
+	v, ok := m.loadAndDelete(key)
+	if ok {
+		m.count.Dec()
+		m.checkReHashThreshold()
+	}
+	return v, ok
+}
+
+func (m *Map) loadAndDelete(key string) (interface{}, bool) {
+	// the lock makes sure buckets are not replaced meanwhile like put
+	m.RLock()
+	defer m.RUnlock()
+
+	bkPkg := m.bkPkg.Load().(*bucketPackage)
+	keyHash := strHash(key)
+	b := bucketOf(keyHash, bkPkg.buckets)
+	oldB := bucketOf(keyHash, bkPkg.oldBuckets)
+	if oldB == nil || oldB.isForwarded() {
+		return b.LoadAndDelete(key)
+	}
+
+	// rehashing, Get and transfer read old buckets for keys not in new buckets,
+	// so the key is kept absent in new buckets until its old bucket is migrated
+	pre, ok := b.swap(key, nil, true)
+	if oldB.isForwarded() {
+		// migrated meanwhile, transfer may have dropped absent keys before the swap
+		b.dropAbsent(func(k interface{}) bool { return k == key })
 	}
+	if ok {
+		if pre.absent {
+			return nil, false
+		}
+		return pre.value, true
+	}
+	return oldB.Get(key)
+}
 
+// needReHash returns the new capacity if the load factor is out of thresholds
+func (m *Map) needReHash() (uint64, bool) {
 	cnt := m.count.Load()
 	cp := m.cap.Load()
 	ratio := float64(cnt) / float64(cp)
 	if ratio >= reHashThreshold {
-		cp = cp << 1
-		//fmt.Println("cap:", cp, "|", cp>>1, cnt, ratio)
-		m.cap.Store(cp)
-		buckets := make([]*bucket, cp)
-		for i := range buckets {
-			buckets[i] = newBucket()
-		}
+		return cp << 1, true
+	}
+	// shrink reusing the same migration
+	if ratio < shrinkThreshold && cp > 1<<initBPower {
+		return cp >> 1, true
+	}
+	return 0, false
+}
 
-		//m.Lock()
-		oldBkPkg := m.bkPkg.Load().(*bucketPackage)
-		m.bkPkg.Store(&bucketPackage{
-			buckets:    buckets,
-			oldBuckets: oldBkPkg.buckets,
-		})
-		//m.Unlock()
+// checkReHashThreshold starts resizing if needed, returning true if the map is resizing
+func (m *Map) checkReHashThreshold() bool {
+	if m.transferIndex.Load() >= 0 {
+		return true
+	}
+	if _, ok := m.needReHash(); !ok {
+		return false
+	}
+
+	m.Lock()
+	defer m.Unlock()
 
-		m.growingIndex.Store(0)
+	if m.transferIndex.Load() >= 0 {
 		return true
 	}
-	return false
-}
+	cp, ok := m.needReHash()
+	if !ok {
+		return false
+	}
 
-func (m *Map) doGrowWork() {
-	if !m.growing.CAS(false, true) {
-		return
+	//fmt.Println("cap:", cp, "|", cp>>1, cnt, ratio)
+	m.cap.Store(cp)
+	buckets := make([]*bucket, cp)
+	for i := range buckets {
+		buckets[i] = newBucket()
 	}
-	defer m.growing.Store(false)
 
-	reHashIndex := m.growingIndex.Load()
-	if reHashIndex < 0 {
+	oldBkPkg := m.bkPkg.Load().(*bucketPackage)
+	m.bkPkg.Store(&bucketPackage{
+		buckets:    buckets,
+		oldBuckets: oldBkPkg.buckets,
+	})
+	m.transferred.Store(0)
+	m.transferIndex.Store(0)
+	return true
+}
+
+// helpTransfer migrates a stride of old buckets while resizing, the helper migrating the last
+// old bucket ends the resize
+func (m *Map) helpTransfer() {
+	if m.transferIndex.Load() < 0 {
 		return
 	}
 
-	// this lock protect Put and following reHashing
-	// make sure use new bucket value first
-	m.Lock()
-	defer m.Unlock()
-	reHashIndex = m.growingIndex.Load()
-	if reHashIndex < 0 {
-		return
+	m.RLock()
+	done := m.transfer()
+	m.RUnlock()
+
+	if done {
+		m.endTransfer()
 	}
+}
 
+// transfer claims a stride of old buckets and migrates them, returning true if all are migrated
+func (m *Map) transfer() bool {
 	bkPkg := m.bkPkg.Load().(*bucketPackage)
 	oldBs := bkPkg.oldBuckets
 	newBs := bkPkg.buckets
+	n := int64(len(oldBs))
+	if n == 0 {
+		return false
+	}
 
-	// rehash end
-	if reHashIndex == int64(len(oldBs)) {
-		// m.oldBuckets = nil
-		bkPkg.oldBuckets = nil
-		// fmt.Println("reHashIndex: +++++++++++", reHashIndex)
-		m.growingIndex.Store(-1)
-		return
+	start := m.transferIndex.Add(transferStride) - transferStride
+	if start < 0 || start >= n {
+		return false
+	}
+	end := start + transferStride
+	if end > n {
+		end = n
 	}
 
-	//fmt.Println("reHashIndex: -----------", reHashIndex)
-	oldBs[reHashIndex].rangeBucket(func(key, value interface{}) {
-		keyHash := strHash(key.(string))
-		i := keyHash % uint64(len(newBs))
-		b := newBs[i]
-		if _, ok := b.Get(key); !ok {
-			b.Put(key, value)
+	for i := start; i < end; i++ {
+		oldB := oldBs[i]
+		oldB.rangeBucket(func(key, value interface{}) {
+			// newer values and deleted keys are kept
+			bucketOf(strHash(key.(string)), newBs).loadOrStore(key, value)
+		})
+		oldB.forward()
+
+		// keys of a migrated old bucket are not read from it, so they need no absent nodes.
+		// Its keys move to the new buckets j with j%n == i growing, or to i%len(newBs) shrinking.
+		fromOldB := func(key interface{}) bool {
+			return strHash(key.(string))%uint64(n) == uint64(i)
 		}
-	})
-	m.growingIndex.Inc()
+		for j := i % int64(len(newBs)); j < int64(len(newBs)); j += n {
+			newBs[j].dropAbsent(fromOldB)
+		}
+	}
+	return m.transferred.Add(end-start) == n
+}
+
+// endTransfer drops old buckets after all old buckets are migrated
+func (m *Map) endTransfer() {
+	m.Lock()
+	defer m.Unlock()
+
+	bkPkg := m.bkPkg.Load().(*bucketPackage)
+	if len(bkPkg.oldBuckets) == 0 {
+		return
+	}
+	m.bkPkg.Store(&bucketPackage{buckets: bkPkg.buckets})
+	m.transferIndex.Store(-1)
 }
@@ -5,6 +5,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"math/rand"
 	"reflect"
+	"runtime"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -318,6 +319,193 @@ func TestNewLockFreeMapPut(t *testing.T) {
 	wg.Wait()
 }
 
+func TestLockFreeBucket_LoadAndDelete(t *testing.T) {
+	b := newBucket()
+	for i := 0; i < 4; i++ {
+		b.Put(i, i)
+	}
+
+	// head, middle and tail
+	for _, i := range []int{0, 2, 3} {
+		v, ok := b.LoadAndDelete(i)
+		assert.True(t, ok)
+		assert.Equal(t, i, v)
+		_, ok = b.Get(i)
+		assert.False(t, ok)
+	}
+	_, ok := b.LoadAndDelete(0)
+	assert.False(t, ok)
+
+	// put after delete appends a new node
+	b.Put(0, 10)
+	v, ok := b.Get(0)
+	assert.True(t, ok)
+	assert.Equal(t, 10, v)
+
+	var keys []interface{}
+	b.rangeBucket(func(key, value interface{}) {
+		keys = append(keys, key)
+	})
+	assert.Equal(t, []interface{}{1, 0}, keys)
+}
+
+func TestLockFreeBucket_ConcurrentDelete(t *testing.T) {
+	b := newBucket()
+	n := 100
+	for i := 0; i < n; i++ {
+		b.Put(i, i)
+	}
+
+	var deleted int32
+	wg := sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if _, ok := b.LoadAndDelete(i); ok {
+					atomic.AddInt32(&deleted, 1)
+				}
+				b.Put(n+i, i)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// each key is deleted once, and no put is lost
+	assert.Equal(t, int32(n), deleted)
+	cnt := 0
+	b.rangeBucket(func(key, value interface{}) {
+		cnt++
+	})
+	assert.Equal(t, n, cnt)
+}
+
+func TestLockFreeMap_Delete(t *testing.T) {
+	m := NewLockFreeMap()
+	n := 1000
+	for i := 0; i < n; i++ {
+		m.Put(strconv.Itoa(i), i)
+	}
+
+	// delete while rehashing, deleted keys are not copied back from old buckets
+	wg := sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < n; i += 4 {
+				if i%2 == 0 {
+					v, ok := m.LoadAndDelete(strconv.Itoa(i))
+					assert.True(t, ok)
+					assert.Equal(t, i, v)
+				}
+				m.Put(strconv.Itoa(n+i), n+i)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// finish rehashing
+	for m.checkReHashThreshold() {
+		m.helpTransfer()
+	}
+
+	for i := 0; i < 2*n; i++ {
+		v, ok := m.Get(strconv.Itoa(i))
+		if i < n && i%2 == 0 {
+			assert.False(t, ok)
+			continue
+		}
+		assert.True(t, ok)
+		assert.Equal(t, i, v)
+	}
+	_, ok := m.LoadAndDelete("0")
+	assert.False(t, ok)
+	m.Delete("1")
+	_, ok = m.Get("1")
+	assert.False(t, ok)
+}
+
+func TestLockFreeMap_CooperativeTransfer(t *testing.T) {
+	m := NewLockFreeMap()
+	goroutines := runtime.NumGoroutine()
+
+	// inserting 6 keys into 8 buckets starts resizing
+	for i := 0; i < 6; i++ {
+		m.Put(strconv.Itoa(i), i)
+	}
+	assert.True(t, m.transferIndex.Load() >= 0)
+	bkPkg := m.bkPkg.Load().(*bucketPackage)
+	oldBs, newBs := bkPkg.oldBuckets, bkPkg.buckets
+	assert.Equal(t, 8, len(oldBs))
+	assert.Equal(t, 16, len(newBs))
+
+	// each read migrates a stride, forwarding lookups of migrated buckets
+	_, _ = m.Get("0")
+	for i := 0; i < transferStride; i++ {
+		assert.True(t, oldBs[i].isForwarded())
+	}
+	assert.False(t, oldBs[transferStride].isForwarded())
+	for i := 0; i < 6; i++ {
+		v, ok := m.Get(strconv.Itoa(i))
+		assert.True(t, ok)
+		assert.Equal(t, i, v)
+	}
+
+	// the last stride ends resizing, without spawning goroutines
+	assert.Equal(t, int64(-1), m.transferIndex.Load())
+	assert.Equal(t, 0, len(m.bkPkg.Load().(*bucketPackage).oldBuckets))
+	assert.Equal(t, goroutines, runtime.NumGoroutine())
+	for i := 0; i < 6; i++ {
+		v, ok := bucketOf(strHash(strconv.Itoa(i)), newBs).Get(strconv.Itoa(i))
+		assert.True(t, ok)
+		assert.Equal(t, i, v)
+	}
+}
+
+func TestLockFreeMap_DropAbsentWhileTransfer(t *testing.T) {
+	m := NewLockFreeMap()
+	for i := 0; i < 6; i++ {
+		m.Put(strconv.Itoa(i), i)
+	}
+	bkPkg := m.bkPkg.Load().(*bucketPackage)
+	oldBs, newBs := bkPkg.oldBuckets, bkPkg.buckets
+
+	// keys deleted before their old buckets are migrated are absent in new buckets
+	for i := 0; i < 6; i++ {
+		_, ok := m.loadAndDelete(strconv.Itoa(i))
+		assert.True(t, ok)
+	}
+	absent := func() map[string]bool {
+		keys := map[string]bool{}
+		for _, b := range newBs {
+			for n := (*node)(atomic.LoadPointer(&b.head)); n != nil; n = n.load().next {
+				if st := n.load(); st.absent && !st.deleted {
+					keys[n.key.(string)] = true
+				}
+			}
+		}
+		return keys
+	}
+	assert.Equal(t, 6, len(absent()))
+
+	// each stride drops the absent keys of the old buckets it migrates
+	m.helpTransfer()
+	for i := 0; i < 6; i++ {
+		key := strconv.Itoa(i)
+		migrated := oldBs[strHash(key)%uint64(len(oldBs))].isForwarded()
+		assert.Equal(t, !migrated, absent()[key])
+	}
+	m.helpTransfer()
+	assert.Equal(t, int64(-1), m.transferIndex.Load())
+	assert.Empty(t, absent())
+	for i := 0; i < 6; i++ {
+		_, ok := m.Get(strconv.Itoa(i))
+		assert.False(t, ok)
+	}
+}
+
 func TestLoop(t *testing.T) {
 	c := 1000
 	wg := sync.WaitGroup{}
@@ -331,3 +519,117 @@ func TestLoop(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestLockFreeMap_LenAndShrink(t *testing.T) {
+	m := NewLockFreeMap()
+	// rehash until the load factor is within thresholds
+	finish := func() {
+		for m.checkReHashThreshold() {
+			m.helpTransfer()
+		}
+	}
+
+	n := 1000
+	for i := 0; i < n; i++ {
+		assert.True(t, m.Put(strconv.Itoa(i), i))
+	}
+	// replacing does not grow the map
+	finish()
+	cp := m.cap.Load()
+	for i := 0; i < n; i++ {
+		assert.False(t, m.Put(strconv.Itoa(i), i+1))
+		finish()
+	}
+	assert.Equal(t, n, m.Len())
+	assert.Equal(t, cp, m.cap.Load())
+
+	// shrink back to the initial size
+	for i := 0; i < n; i++ {
+		m.Delete(strconv.Itoa(i))
+		finish()
+		if i%100 == 0 {
+			assert.Equal(t, n-i-1, m.Len())
+		}
+	}
+	assert.Equal(t, 0, m.Len())
+	assert.Equal(t, uint64(1<<initBPower), m.cap.Load())
+	assert.True(t, m.Put("0", 0))
+	assert.Equal(t, 1, m.Len())
+}
+
+func TestRedBlackMap_LenAndShrink(t *testing.T) {
+	m := NewRedBlackMap()
+	// rehash until the load factor is within thresholds
+	finish := func() {
+		for m.checkReHashThreshold() {
+			m.reHashing()
+		}
+	}
+	capOf := func() uint64 {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		return m.cap
+	}
+
+	n := 1000
+	for i := 0; i < n; i++ {
+		assert.True(t, m.Put(strconv.Itoa(i), i))
+	}
+	finish()
+	cp := capOf()
+	for i := 0; i < n; i++ {
+		assert.False(t, m.Put(strconv.Itoa(i), i+1))
+		finish()
+	}
+	assert.Equal(t, n, m.Len())
+	assert.Equal(t, cp, capOf())
+
+	for i := 0; i < n; i++ {
+		v, ok := m.LoadAndDelete(strconv.Itoa(i))
+		assert.True(t, ok)
+		assert.Equal(t, i+1, v)
+		finish()
+	}
+	assert.Equal(t, 0, m.Len())
+	assert.Equal(t, uint64(1<<initBPower), capOf())
+	_, ok := m.Get("0")
+	assert.False(t, ok)
+}
+
+func TestRedBlackMap_ConcurrentDelete(t *testing.T) {
+	m := NewRedBlackMap()
+	n := 1000
+	for i := 0; i < n; i++ {
+		m.Put(strconv.Itoa(i), i)
+	}
+
+	// deleted keys are not copied back from old buckets while rehashing
+	var deleted int32
+	wg := sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < n; i += 4 {
+				if _, ok := m.LoadAndDelete(strconv.Itoa(i)); ok {
+					atomic.AddInt32(&deleted, 1)
+				}
+				m.Put(strconv.Itoa(n+i), n+i)
+			}
+		}(w)
+	}
+	wg.Wait()
+	for m.buckets.Load().(redBlackBucket).reHashing {
+		m.reHashing()
+	}
+
+	assert.Equal(t, int32(n), deleted)
+	assert.Equal(t, n, m.Len())
+	for i := 0; i < n; i++ {
+		_, ok := m.Get(strconv.Itoa(i))
+		assert.False(t, ok)
+		v, ok := m.Get(strconv.Itoa(n + i))
+		assert.True(t, ok)
+		assert.Equal(t, n+i, v)
+	}
+}
@@ -15,8 +15,9 @@ type redBlackBucket struct {
 type RedBlackMap struct {
 	mu sync.RWMutex
 
-	cap   uint64
-	count *atomic.Uint64
+	cap uint64
+	// count of keys, updated after the buckets so it may be transiently negative
+	count *atomic.Int64
 
 	buckets    *atomic.Value
 	oldBuckets *atomic.Value
@@ -29,7 +30,7 @@ type RedBlackMap struct {
 func NewRedBlackMap() *RedBlackMap {
 	m := &RedBlackMap{
 		cap:   uint64(math.Pow(2, initBPower)),
-		count: atomic.NewUint64(0),
+		count: atomic.NewInt64(0),
 
 		oldBuckets: &atomic.Value{},
 		buckets:    &atomic.Value{},
@@ -86,31 +87,100 @@ func (m *RedBlackMap) getFromBucket(key string, keyHash uint64) (interface{}, bo
 
 func (m *RedBlackMap) getFromOldBucket(key string, keyHash uint64) (interface{}, bool) {
 	rbBucket := m.oldBuckets.Load().(redBlackBucket)
+	if len(rbBucket.b) == 0 {
+		return nil, false
+	}
 	i := keyHash % uint64(len(rbBucket.b))
 	b := rbBucket.b[i]
 
 	return b.get(key)
 }
 
-func (m *RedBlackMap) Put(key string, value interface{}) {
+// Put sets the value of key, returning true if key is inserted, false if its value is replaced
+func (m *RedBlackMap) Put(key string, value interface{}) bool {
 	m.mu.RLock()
-	m.put(key, value)
+	inserted := m.put(key, value)
 	m.mu.RUnlock()
 
-	m.count.Add(1)
+	if inserted {
+		m.count.Inc()
+	}
 
 	// check if map need rehash
 	if m.checkReHashThreshold() {
 		go m.reHashing()
 	}
+	return inserted
 }
 
-func (m *RedBlackMap) put(key string, value interface{}) {
+func (m *RedBlackMap) put(key string, value interface{}) bool {
 	keyHash := strHash(key)
 	curBucket := m.buckets.Load().(redBlackBucket)
 	i := keyHash % uint64(len(curBucket.b))
 	b := curBucket.b[i]
-	b.put(key, value)
+	if b.put(key, value) {
+		return false
+	}
+
+	// the key may be not copied from old buckets yet
+	if curBucket.reHashing {
+		_, ok := m.getFromOldBucket(key, keyHash)
+		return !ok
+	}
+	return true
+}
+
+// Delete deletes the value of key
+func (m *RedBlackMap) Delete(key string) {
+	m.LoadAndDelete(key)
+}
+
+// LoadAndDelete deletes the value of key, returning the previous value if any
+func (m *RedBlackMap) LoadAndDelete(key string) (interface{}, bool) {
+	value, ok := m.loadAndDelete(key)
+	if ok {
+		m.count.Dec()
+		if m.checkReHashThreshold() {
+			go m.reHashing()
+		}
+	}
+	return value, ok
+}
+
+func (m *RedBlackMap) loadAndDelete(key string) (interface{}, bool) {
+	keyHash := strHash(key)
+
+	m.mu.RLock()
+	curBucket := m.buckets.Load().(redBlackBucket)
+	if !curBucket.reHashing {
+		defer m.mu.RUnlock()
+		return curBucket.b[keyHash%uint64(len(curBucket.b))].delete(key)
+	}
+	m.mu.RUnlock()
+
+	// rehashing, delete the key from both buckets so it is not copied back
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	curBucket = m.buckets.Load().(redBlackBucket)
+	value, ok := curBucket.b[keyHash%uint64(len(curBucket.b))].delete(key)
+	if !curBucket.reHashing {
+		return value, ok
+	}
+	oldBucket := m.oldBuckets.Load().(redBlackBucket)
+	oldValue, oldOk := oldBucket.b[keyHash%uint64(len(oldBucket.b))].delete(key)
+	if ok {
+		return value, ok
+	}
+	return oldValue, oldOk
+}
+
+// Len returns the number of keys
+func (m *RedBlackMap) Len() int {
+	if n := m.count.Load(); n > 0 {
+		return int(n)
+	}
+	return 0
 }
 
 func (m *RedBlackMap) checkReHashThreshold() bool {
@@ -120,9 +190,11 @@ func (m *RedBlackMap) checkReHashThreshold() bool {
 	}
 	m.mu.RLock()
 	ratio := float64(m.count.Load()) / float64(m.cap)
+	canShrink := m.cap > 1<<initBPower
 	m.mu.RUnlock()
 
-	if ratio >= reHashThreshold {
+	// grow, or shrink reusing the same migration
+	if ratio >= reHashThreshold || (ratio < shrinkThreshold && canShrink) {
 		m.mu.Lock()
 		defer m.mu.Unlock()
 
@@ -131,7 +203,14 @@ func (m *RedBlackMap) checkReHashThreshold() bool {
 			return true
 		}
 
-		m.cap = m.cap << 1 // cap * 2
+		ratio = float64(m.count.Load()) / float64(m.cap)
+		if ratio >= reHashThreshold {
+			m.cap = m.cap << 1 // cap * 2
+		} else if ratio < shrinkThreshold && m.cap > 1<<initBPower {
+			m.cap = m.cap >> 1 // cap / 2
+		} else {
+			return false
+		}
 		bs := m.cap
 		buckets := make([]*redBlackTree, bs)
 		for i := uint64(0); i < bs; i++ {
@@ -172,14 +251,14 @@ func (m *RedBlackMap) reHashing() {
 		i := keyHash % uint64(len(curBucket.b))
 		b := curBucket.b[i]
 		if _, ok := b.get(key); !ok {
-			m.put(key.(string), value)
+			b.put(key, value)
 		}
 	})
 
 	// rehash end
 	if reHashIndex == int64(len(oldBucket.b)-1) {
-		oldBucket.b = nil
-		curBucket.reHashing = false
+		m.oldBuckets.Store(redBlackBucket{})
+		m.buckets.Store(redBlackBucket{b: curBucket.b})
 		m.reHashIndex = 0
 	} else {
 		m.reHashIndex++
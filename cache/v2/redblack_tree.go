@@ -21,18 +21,26 @@ func (t *redBlackTree) get(key interface{}) (value interface{}, found bool) {
 	return t.tree.Get(key)
 }
 
-func (t *redBlackTree) put(key, value interface{}) {
+// put returns true if key existed
+func (t *redBlackTree) put(key, value interface{}) bool {
 	t.Lock()
 	defer t.Unlock()
 
+	_, found := t.tree.Get(key)
 	t.tree.Put(key, value)
+	return found
 }
 
-func (t *redBlackTree) delete(key interface{}) {
+// delete returns the value of key if it existed
+func (t *redBlackTree) delete(key interface{}) (interface{}, bool) {
 	t.Lock()
 	defer t.Unlock()
 
-	t.tree.Remove(key)
+	value, found := t.tree.Get(key)
+	if found {
+		t.tree.Remove(key)
+	}
+	return value, found
 }
 
 func (t *redBlackTree) rangeTree(f func(key, value interface{})) {
@@ -0,0 +1,111 @@
+package v2
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// RendezvousHash is weighted rendezvous (highest random weight) hashing, each key goes
+// to the node with the highest score of hash(node, key) and node weight.
+//
+// Adding or removing any node only moves the keys of that node, lookups cost O(nodes).
+type RendezvousHash struct {
+	mu sync.RWMutex
+
+	weights map[string]int
+}
+
+// NewRendezvousHash new a rendezvous hash
+func NewRendezvousHash() *RendezvousHash {
+	return &RendezvousHash{weights: make(map[string]int)}
+}
+
+// rendezvousScore returns -weight / ln(u) where u is hash(node, key) uniform in (0, 1),
+// so the probability of a node having the highest score is proportional to its weight.
+func rendezvousScore(node, key string, weight int) float64 {
+	h := strHash(node + string(SeparatorByte) + key)
+	u := (float64(h>>11) + 0.5) / (1 << 53)
+	return -float64(weight) / math.Log(u)
+}
+
+// Add ...
+func (r *RendezvousHash) Add(node string, weight int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if weight <= 0 {
+		delete(r.weights, node)
+		return
+	}
+	r.weights[node] = weight
+}
+
+// Remove ...
+func (r *RendezvousHash) Remove(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.weights, node)
+}
+
+// Get ...
+func (r *RendezvousHash) Get(key string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var (
+		best      string
+		bestScore = math.Inf(-1)
+	)
+	for node, w := range r.weights {
+		score := rendezvousScore(node, key, w)
+		// compare node on tie to be deterministic
+		if score > bestScore || (score == bestScore && node < best) {
+			best, bestScore = node, score
+		}
+	}
+	return best, len(r.weights) > 0
+}
+
+// GetN ...
+func (r *RendezvousHash) GetN(key string, n int) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.weights) == 0 || n <= 0 {
+		return nil
+	}
+
+	type scored struct {
+		node  string
+		score float64
+	}
+	all := make([]scored, 0, len(r.weights))
+	for node, w := range r.weights {
+		all = append(all, scored{node: node, score: rendezvousScore(node, key, w)})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].score != all[j].score {
+			return all[i].score > all[j].score
+		}
+		return all[i].node < all[j].node
+	})
+
+	if n > len(all) {
+		n = len(all)
+	}
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = all[i].node
+	}
+	return nodes
+}
+
+// Nodes ...
+func (r *RendezvousHash) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return sortedNodes(r.weights)
+}
@@ -0,0 +1,275 @@
+package v2
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"unsafe"
+
+	uatomic "go.uber.org/atomic"
+)
+
+const (
+	// splitOrderedMaxLoad average number of keys per bucket doubling the buckets
+	splitOrderedMaxLoad = 2
+	// one segment of bucket 0, and segment k holds buckets [2^(k-1), 2^k)
+	splitOrderedSegments = 65
+)
+
+// soNode a node of the split-ordered list, a regular node holds a key,
+// a dummy node starts a bucket and is never deleted
+type soNode struct {
+	// soKey the reversed hash, odd for regular nodes and even for dummy nodes
+	soKey uint64
+	key   string
+	// state *soState, replaced by cas
+	state unsafe.Pointer
+}
+
+// soState an immutable state of a node, deleted marks the node as logically deleted
+// so nothing is linked after it while unlinked, like bucket nodes
+type soState struct {
+	value   interface{}
+	next    *soNode
+	deleted bool
+}
+
+func (n *soNode) load() *soState {
+	return (*soState)(atomic.LoadPointer(&n.state))
+}
+
+func (n *soNode) cas(old, new *soState) bool {
+	return atomic.CompareAndSwapPointer(&n.state, unsafe.Pointer(old), unsafe.Pointer(new))
+}
+
+// less reports whether n is ordered before soKey and key
+func (n *soNode) less(soKey uint64, key string) bool {
+	if n.soKey != soKey {
+		return n.soKey < soKey
+	}
+	return n.key < key
+}
+
+func (n *soNode) equal(soKey uint64, key string) bool {
+	return n.soKey == soKey && n.key == key
+}
+
+// regularKey keys are ordered by the reversed hash, so the keys of a bucket follow
+// its dummy node and a bucket is split by inserting a dummy node in the middle
+func regularKey(keyHash uint64) uint64 {
+	return bits.Reverse64(keyHash | 1<<63)
+}
+
+func dummyKey(bucket uint64) uint64 {
+	return bits.Reverse64(bucket)
+}
+
+// SplitOrderedMap is a lock-free hash map of split-ordered lists by Shalev and Shavit.
+// All keys are in one sorted lock-free list, buckets are shortcuts into the list and
+// initialized on first use from their parent bucket, so doubling buckets moves no keys
+// and no operation blocks.
+type SplitOrderedMap struct {
+	// segments of buckets, *[]unsafe.Pointer of *soNode created on first use
+	segments [splitOrderedSegments]unsafe.Pointer
+
+	// size number of buckets, a power of 2
+	size  *uatomic.Uint64
+	count *uatomic.Int64
+}
+
+// NewSplitOrderedMap new a split-ordered map
+func NewSplitOrderedMap() *SplitOrderedMap {
+	m := &SplitOrderedMap{
+		size:  uatomic.NewUint64(1 << initBPower),
+		count: uatomic.NewInt64(0),
+	}
+	head := &soNode{soKey: dummyKey(0), state: unsafe.Pointer(&soState{})}
+	*m.slot(0) = unsafe.Pointer(head)
+	return m
+}
+
+// slot returns the slot of bucket b, creating its segment if needed
+func (m *SplitOrderedMap) slot(b uint64) *unsafe.Pointer {
+	k := bits.Len64(b)
+	var offset, n uint64 = 0, 1
+	if k > 0 {
+		offset, n = b-1<<(k-1), 1<<(k-1)
+	}
+
+	seg := (*[]unsafe.Pointer)(atomic.LoadPointer(&m.segments[k]))
+	if seg == nil {
+		newSeg := make([]unsafe.Pointer, n)
+		if !atomic.CompareAndSwapPointer(&m.segments[k], nil, unsafe.Pointer(&newSeg)) {
+			seg = (*[]unsafe.Pointer)(atomic.LoadPointer(&m.segments[k]))
+		} else {
+			seg = &newSeg
+		}
+	}
+	return &(*seg)[offset]
+}
+
+// bucket returns the dummy node of bucket b, initializing it from its parent if needed
+func (m *SplitOrderedMap) bucket(b uint64) *soNode {
+	slot := m.slot(b)
+	if d := (*soNode)(atomic.LoadPointer(slot)); d != nil {
+		return d
+	}
+
+	// the parent bucket holds the keys of b before the split
+	parent := m.bucket(b &^ (1 << (bits.Len64(b) - 1)))
+	d := &soNode{soKey: dummyKey(b), state: unsafe.Pointer(&soState{})}
+	if n, loaded := m.insert(parent, d); loaded {
+		d = n
+	}
+	atomic.CompareAndSwapPointer(slot, nil, unsafe.Pointer(d))
+	return (*soNode)(atomic.LoadPointer(slot))
+}
+
+func (m *SplitOrderedMap) bucketOf(keyHash uint64) *soNode {
+	return m.bucket(keyHash & (m.size.Load() - 1))
+}
+
+// find walks from the dummy node start unlinking deleted nodes, returning the last node
+// before soKey and key, and the node following it. ok is false if the walk must be retried.
+func (m *SplitOrderedMap) find(start *soNode, soKey uint64, key string) (
+	prev *soNode, prevSt *soState, cur *soNode, curSt *soState, ok bool) {
+	prev, prevSt = start, start.load()
+	cur = prevSt.next
+	for cur != nil {
+		curSt = cur.load()
+		if curSt.deleted {
+			if !prev.cas(prevSt, &soState{value: prevSt.value, next: curSt.next}) {
+				return nil, nil, nil, nil, false
+			}
+			prevSt = prev.load()
+			if prevSt.deleted {
+				return nil, nil, nil, nil, false
+			}
+			cur = curSt.next
+			continue
+		}
+		if !cur.less(soKey, key) {
+			return prev, prevSt, cur, curSt, true
+		}
+		prev, prevSt = cur, curSt
+		cur = curSt.next
+	}
+	return prev, prevSt, nil, nil, true
+}
+
+// insert links n after start unless a node of its key exists, returning the existing node
+func (m *SplitOrderedMap) insert(start, n *soNode) (*soNode, bool) {
+	for {
+		prev, prevSt, cur, _, ok := m.find(start, n.soKey, n.key)
+		if !ok {
+			continue
+		}
+		if cur != nil && cur.equal(n.soKey, n.key) {
+			return cur, true
+		}
+		n.state = unsafe.Pointer(&soState{value: n.load().value, next: cur})
+		if prev.cas(prevSt, &soState{value: prevSt.value, next: n}) {
+			return n, false
+		}
+	}
+}
+
+// Get returns the value of key, it never blocks
+func (m *SplitOrderedMap) Get(key string) (interface{}, bool) {
+	keyHash := strHash(key)
+	soKey := regularKey(keyHash)
+	n := m.bucketOf(keyHash).load().next
+	for n != nil {
+		st := n.load()
+		if !n.less(soKey, key) {
+			if n.equal(soKey, key) && !st.deleted {
+				return st.value, true
+			}
+			if !n.equal(soKey, key) {
+				return nil, false
+			}
+		}
+		n = st.next
+	}
+	return nil, false
+}
+
+// Put sets the value of key, returning true if key is inserted, false if its value is replaced
+func (m *SplitOrderedMap) Put(key string, value interface{}) bool {
+	keyHash := strHash(key)
+	soKey := regularKey(keyHash)
+	start := m.bucketOf(keyHash)
+	for {
+		prev, prevSt, cur, curSt, ok := m.find(start, soKey, key)
+		if !ok {
+			continue
+		}
+		if cur != nil && cur.equal(soKey, key) {
+			if cur.cas(curSt, &soState{value: value, next: curSt.next}) {
+				return false
+			}
+			continue
+		}
+		n := &soNode{soKey: soKey, key: key, state: unsafe.Pointer(&soState{value: value, next: cur})}
+		if prev.cas(prevSt, &soState{value: prevSt.value, next: n}) {
+			m.inserted()
+			return true
+		}
+	}
+}
+
+// LoadOrStore returns the value of key if present, otherwise stores value
+func (m *SplitOrderedMap) LoadOrStore(key string, value interface{}) (actual interface{}, loaded bool) {
+	keyHash := strHash(key)
+	soKey := regularKey(keyHash)
+	n := &soNode{soKey: soKey, key: key, state: unsafe.Pointer(&soState{value: value})}
+	if cur, loaded := m.insert(m.bucketOf(keyHash), n); loaded {
+		return cur.load().value, true
+	}
+	m.inserted()
+	return value, false
+}
+
+// inserted counts an inserted key, doubling buckets if the load is too high
+func (m *SplitOrderedMap) inserted() {
+	cnt := m.count.Inc()
+	size := m.size.Load()
+	if uint64(cnt) > size*splitOrderedMaxLoad {
+		m.size.CAS(size, size<<1)
+	}
+}
+
+// Delete deletes the value of key
+func (m *SplitOrderedMap) Delete(key string) {
+	m.LoadAndDelete(key)
+}
+
+// LoadAndDelete deletes the value of key, returning the previous value if any.
+// The node is marked deleted first, then unlinked by this or a later walk.
+func (m *SplitOrderedMap) LoadAndDelete(key string) (interface{}, bool) {
+	keyHash := strHash(key)
+	soKey := regularKey(keyHash)
+	start := m.bucketOf(keyHash)
+	for {
+		_, _, cur, curSt, ok := m.find(start, soKey, key)
+		if !ok {
+			continue
+		}
+		if cur == nil || !cur.equal(soKey, key) {
+			return nil, false
+		}
+		if cur.cas(curSt, &soState{value: curSt.value, next: curSt.next, deleted: true}) {
+			m.count.Dec()
+			// unlink it
+			m.find(start, soKey, key)
+			return curSt.value, true
+		}
+	}
+}
+
+// Len returns the number of keys
+func (m *SplitOrderedMap) Len() int {
+	if n := m.count.Load(); n > 0 {
+		return int(n)
+	}
+	return 0
+}
@@ -0,0 +1,139 @@
+package v2
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitOrderedMap(t *testing.T) {
+	m := NewSplitOrderedMap()
+	n := 10000
+	for i := 0; i < n; i++ {
+		assert.True(t, m.Put(strconv.Itoa(i), i))
+	}
+	assert.Equal(t, n, m.Len())
+	// buckets are doubled without moving keys
+	assert.True(t, m.size.Load() >= uint64(n/splitOrderedMaxLoad))
+
+	for i := 0; i < n; i++ {
+		assert.False(t, m.Put(strconv.Itoa(i), i+1))
+		v, ok := m.Get(strconv.Itoa(i))
+		assert.True(t, ok)
+		assert.Equal(t, i+1, v)
+	}
+
+	actual, loaded := m.LoadOrStore("0", 0)
+	assert.True(t, loaded)
+	assert.Equal(t, 1, actual)
+	actual, loaded = m.LoadOrStore("new", 0)
+	assert.False(t, loaded)
+	assert.Equal(t, 0, actual)
+
+	for i := 0; i < n; i += 2 {
+		v, ok := m.LoadAndDelete(strconv.Itoa(i))
+		assert.True(t, ok)
+		assert.Equal(t, i+1, v)
+	}
+	m.Delete("new")
+	assert.Equal(t, n/2, m.Len())
+	for i := 0; i < n; i++ {
+		_, ok := m.Get(strconv.Itoa(i))
+		assert.Equal(t, i%2 == 1, ok)
+	}
+
+	// the list stays ordered by split-order keys
+	var pre *soNode
+	for cur := m.bucket(0); cur != nil; cur = cur.load().next {
+		if pre != nil {
+			assert.True(t, pre.less(cur.soKey, cur.key))
+		}
+		pre = cur
+	}
+}
+
+func TestSplitOrderedMap_Concurrent(t *testing.T) {
+	m := NewSplitOrderedMap()
+	n := 2000
+	var inserted, deleted int32
+	wg := sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if _, loaded := m.LoadOrStore(strconv.Itoa(i), i); !loaded {
+					atomic.AddInt32(&inserted, 1)
+				}
+				if i%2 == 0 {
+					if _, ok := m.LoadAndDelete(strconv.Itoa(i)); ok {
+						atomic.AddInt32(&deleted, 1)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int(inserted-deleted), m.Len())
+	for i := 1; i < n; i += 2 {
+		v, ok := m.Get(strconv.Itoa(i))
+		assert.True(t, ok)
+		assert.Equal(t, i, v)
+	}
+}
+
+func TestSplitOrderedMap_Linearizable(t *testing.T) {
+	checkMapLinearizable(t, func() linMap { return NewSplitOrderedMap() })
+}
+
+// benchMap runs Get and Put of increasing keys, reads percent of operations are Get
+func benchMap(B *testing.B, m linMap, reads int) {
+	var index int64
+	for i := 0; i < 1024; i++ {
+		m.Put(strconv.Itoa(i), i)
+	}
+
+	B.ResetTimer()
+	B.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		id := int(atomic.AddInt64(&index, 1))
+		i := id * B.N
+		for ; pb.Next(); i++ {
+			if r.Intn(100) >= reads {
+				m.Put(strconv.Itoa(i), i)
+				continue
+			}
+			_, _ = m.Get(strconv.Itoa(r.Intn(1024)))
+		}
+	})
+}
+
+func BenchmarkSplitOrderedMap_PutOrGet(B *testing.B) {
+	benchMap(B, NewSplitOrderedMap(), 50)
+}
+
+func BenchmarkLockFreeMap_PutOrGet(B *testing.B) {
+	benchMap(B, NewLockFreeMap(), 50)
+}
+
+func BenchmarkRedBlackMap_PutOrGet(B *testing.B) {
+	benchMap(B, NewRedBlackMap(), 50)
+}
+
+func BenchmarkSplitOrderedMap_MostlyGet(B *testing.B) {
+	benchMap(B, NewSplitOrderedMap(), 90)
+}
+
+func BenchmarkLockFreeMap_MostlyGet(B *testing.B) {
+	benchMap(B, NewLockFreeMap(), 90)
+}
+
+func BenchmarkRedBlackMap_MostlyGet(B *testing.B) {
+	benchMap(B, NewRedBlackMap(), 90)
+}
@@ -0,0 +1,308 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	defaultWarmConcurrency = 4
+	defaultWarmBatchSize   = 64
+)
+
+var (
+	// ErrNoLoader neither a Loader nor a BulkLoader is set
+	ErrNoLoader = errors.New("no loader")
+)
+
+// BulkLoader loads values of keys at once, keys missing in the result are not found
+type BulkLoader func(keys []string) map[string]*Value
+
+// WarmProgress progress of a warm-up
+type WarmProgress struct {
+	// Loaded number of keys loaded into the cache
+	Loaded int
+	// Failed number of keys failed to load
+	Failed int
+	// Skipped number of keys already in the cache unexpired or not stored because the cache is full
+	Skipped int
+}
+
+// WarmResult result of a warm-up
+type WarmResult struct {
+	WarmProgress
+
+	// Failures errors of failed keys
+	Failures map[string]error
+	// Full is true if the warm-up stopped because the cache reached MaxSize
+	Full bool
+}
+
+// WarmOptions options for warm-up
+type WarmOptions struct {
+	concurrency int
+	rate        float64
+
+	bulkLoader BulkLoader
+	batchSize  int
+
+	entryOpts  []EntryOption
+	onProgress func(progress WarmProgress)
+}
+
+// WarmOption ...
+type WarmOption func(options *WarmOptions)
+
+// NewWarmOptions new a warm options
+func NewWarmOptions() WarmOptions {
+	return WarmOptions{
+		concurrency: defaultWarmConcurrency,
+		batchSize:   defaultWarmBatchSize,
+	}
+}
+
+// WarmConcurrency set max concurrent loader calls
+func WarmConcurrency(n int) WarmOption {
+	return func(options *WarmOptions) {
+		options.concurrency = n
+	}
+}
+
+// WarmRate set max loader calls per second, a bulk loader call counts once, no limit if not set
+func WarmRate(perSecond float64) WarmOption {
+	return func(options *WarmOptions) {
+		options.rate = perSecond
+	}
+}
+
+// WarmBulkLoader set a bulk loader called with batches of at most batchSize keys
+// instead of the Loader
+func WarmBulkLoader(f BulkLoader, batchSize int) WarmOption {
+	return func(options *WarmOptions) {
+		options.bulkLoader = f
+		options.batchSize = batchSize
+	}
+}
+
+// WarmEntryOpts set entry options of the loaded entries, including the Loader if not the default one
+func WarmEntryOpts(opts ...EntryOption) WarmOption {
+	return func(options *WarmOptions) {
+		options.entryOpts = append(options.entryOpts, opts...)
+	}
+}
+
+// OnWarmProgress set a callback called after each loader call
+func OnWarmProgress(f func(progress WarmProgress)) WarmOption {
+	return func(options *WarmOptions) {
+		options.onProgress = f
+	}
+}
+
+// warmState state shared by warm-up workers
+type warmState struct {
+	mu     sync.Mutex
+	result WarmResult
+	opts   WarmOptions
+}
+
+func (s *warmState) report(loaded, skipped int, failures map[string]error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.result.Loaded += loaded
+	s.result.Skipped += skipped
+	s.result.Failed += len(failures)
+	for key, err := range failures {
+		s.result.Failures[key] = err
+	}
+	if s.opts.onProgress != nil {
+		s.opts.onProgress(s.result.WarmProgress)
+	}
+}
+
+// Warm loads keys received from keys into the cache until keys is closed, ctx is done or the
+// cache reaches MaxSize, without evicting any entry. Keys already in the cache are skipped.
+// It returns ctx.Err() if ctx is done before keys is closed.
+func (cache *LRUCache) Warm(ctx context.Context, keys <-chan string, opts ...WarmOption) (WarmResult, error) {
+	wOpts := NewWarmOptions()
+	for _, o := range opts {
+		o(&wOpts)
+	}
+	if wOpts.concurrency <= 0 {
+		wOpts.concurrency = 1
+	}
+	if wOpts.batchSize <= 0 {
+		wOpts.batchSize = 1
+	}
+
+	eOpts := cache.opts.defaultEntryOpts
+	for _, o := range wOpts.entryOpts {
+		o(&eOpts)
+	}
+	if eOpts.loader == nil && wOpts.bulkLoader == nil {
+		return WarmResult{}, ErrNoLoader
+	}
+
+	batchSize := 1
+	if wOpts.bulkLoader != nil {
+		batchSize = wOpts.batchSize
+	}
+
+	state := &warmState{
+		result: WarmResult{Failures: make(map[string]error)},
+		opts:   wOpts,
+	}
+
+	var limiter <-chan time.Time
+	if wOpts.rate > 0 {
+		t := cache.opts.clock.NewTicker(time.Duration(float64(time.Second) / wOpts.rate))
+		defer t.Stop()
+		limiter = t.C()
+	}
+
+	batches := make(chan []string)
+	var wg sync.WaitGroup
+	for i := 0; i < wOpts.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				if limiter != nil {
+					select {
+					case <-limiter:
+					case <-ctx.Done():
+						continue
+					}
+				}
+				if wOpts.bulkLoader != nil {
+					cache.warmBatch(batch, eOpts, wOpts.bulkLoader, state)
+				} else {
+					cache.warmKey(batch[0], eOpts, state)
+				}
+			}
+		}()
+	}
+
+	err := cache.dispatchWarm(ctx, keys, batches, batchSize, state)
+	close(batches)
+	wg.Wait()
+
+	return state.result, err
+}
+
+// dispatchWarm sends batches of keys not in the cache to workers
+func (cache *LRUCache) dispatchWarm(ctx context.Context, keys <-chan string, batches chan<- []string, batchSize int, state *warmState) error {
+	batch := make([]string, 0, batchSize)
+	send := func() error {
+		select {
+		case batches <- batch:
+			batch = make([]string, 0, batchSize)
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case key, ok := <-keys:
+			if !ok {
+				if len(batch) > 0 {
+					return send()
+				}
+				return nil
+			}
+
+			if cache.Size() >= cache.opts.maxSize {
+				state.mu.Lock()
+				state.result.Full = true
+				state.mu.Unlock()
+				return nil
+			}
+			if _, ok := cache.getUnexpired(key); ok {
+				state.report(0, 1, nil)
+				continue
+			}
+
+			batch = append(batch, key)
+			if len(batch) == batchSize {
+				if err := send(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (cache *LRUCache) warmKey(key string, eOpts EntryOptions, state *warmState) {
+	entry := cache.callLoader(key, eOpts)
+	if entry.innerValue.Err != nil {
+		state.report(0, 0, map[string]error{key: entry.innerValue.Err})
+		return
+	}
+
+	if cache.addIfAbsent(entry) {
+		state.report(1, 0, nil)
+	} else {
+		state.report(0, 1, nil)
+	}
+}
+
+func (cache *LRUCache) warmBatch(keys []string, eOpts EntryOptions, bulkLoader BulkLoader, state *warmState) {
+	values := bulkLoader(keys)
+
+	var loaded, skipped int
+	failures := make(map[string]error)
+	expiration := cache.opts.clock.Now().Add(eOpts.expireAfterWrite).UnixNano()
+	for _, key := range keys {
+		v, ok := values[key]
+		if !ok || v == nil {
+			failures[key] = ErrNotFound
+			continue
+		}
+		if v.Err != nil {
+			failures[key] = v.Err
+			continue
+		}
+
+		entry := &internalEntry{
+			key:        key,
+			innerValue: v,
+			expiration: expiration,
+			refreshed:  atomic.NewBool(false),
+		}
+		if cache.addIfAbsent(entry) {
+			loaded++
+		} else {
+			skipped++
+		}
+	}
+	state.report(loaded, skipped, failures)
+}
+
+// addIfAbsent adds entry if its key is not in the cache and the cache is not full,
+// or replaces the element of its key if it is expired
+func (cache *LRUCache) addIfAbsent(entry *internalEntry) bool {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if e, ok := cache.values[entry.key]; ok {
+		if e.Value.(*internalEntry).expiration > cache.opts.clock.Now().UnixNano() {
+			return false
+		}
+		e.Value = entry
+		cache.lruMoveToFront(e)
+		return true
+	}
+	if len(cache.values) >= cache.opts.maxSize {
+		return false
+	}
+	cache.addItem(entry)
+	return true
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func warmKeys(n int) <-chan string {
+	keys := make(chan string, n)
+	for i := 0; i < n; i++ {
+		keys <- strconv.Itoa(i)
+	}
+	close(keys)
+	return keys
+}
+
+func TestLRUCache_Warm(t *testing.T) {
+	var running, maxRunning int32
+	cache := NewLRUCache(DefaultEntryOpts(WithLoader(func(key string) *Value {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+
+		if key == "13" {
+			return &Value{Err: errOrigin}
+		}
+		return &Value{Val: key}
+	})))
+	cache.Put("7", "cached", ExpirationOption(time.Hour))
+
+	var mu sync.Mutex
+	var progress []WarmProgress
+	result, err := cache.Warm(context.Background(), warmKeys(20),
+		WarmConcurrency(3),
+		OnWarmProgress(func(p WarmProgress) {
+			mu.Lock()
+			progress = append(progress, p)
+			mu.Unlock()
+		}))
+	assert.Nil(t, err)
+	assert.Equal(t, WarmProgress{Loaded: 18, Failed: 1, Skipped: 1}, result.WarmProgress)
+	assert.Equal(t, map[string]error{"13": errOrigin}, result.Failures)
+	assert.False(t, result.Full)
+	assert.Equal(t, 20, len(progress))
+	assert.Equal(t, result.WarmProgress, progress[len(progress)-1])
+	assert.True(t, atomic.LoadInt32(&maxRunning) <= 3)
+
+	assert.Equal(t, 19, cache.Size())
+	v, _ := cache.Get("7")
+	assert.Equal(t, "cached", v.Val)
+	_, ok := cache.Get("13")
+	assert.False(t, ok)
+}
+
+func TestLRUCache_WarmBulk(t *testing.T) {
+	cache := NewLRUCache(MaxSize(10))
+
+	var calls int32
+	bulk := func(keys []string) map[string]*Value {
+		atomic.AddInt32(&calls, 1)
+		values := make(map[string]*Value, len(keys))
+		for _, k := range keys {
+			if k != "3" {
+				values[k] = &Value{Val: k}
+			}
+		}
+		return values
+	}
+
+	result, err := cache.Warm(context.Background(), warmKeys(8), WarmBulkLoader(bulk, 3), WarmConcurrency(1))
+	assert.Nil(t, err)
+	assert.Equal(t, WarmProgress{Loaded: 7, Failed: 1}, result.WarmProgress)
+	assert.Equal(t, ErrNotFound, result.Failures["3"])
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// stops at MaxSize without evicting
+	keys := make(chan string, 10)
+	for i := 100; i < 110; i++ {
+		keys <- strconv.Itoa(i)
+	}
+	close(keys)
+	result, err = cache.Warm(context.Background(), keys, WarmBulkLoader(bulk, 1))
+	assert.Nil(t, err)
+	assert.True(t, result.Full)
+	assert.Equal(t, 10, cache.Size())
+	v, _ := cache.Get("0")
+	assert.Equal(t, "0", v.Val)
+}
+
+func TestLRUCache_WarmExpired(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cache := NewLRUCache(WithClock(clock))
+	cache.Put("0", "stale", ExpirationOption(time.Second))
+	cache.Put("1", "fresh", ExpirationOption(time.Hour))
+	clock.Advance(2 * time.Second)
+
+	var calls int32
+	result, err := cache.Warm(context.Background(), warmKeys(2), WarmEntryOpts(WithLoader(func(key string) *Value {
+		atomic.AddInt32(&calls, 1)
+		return &Value{Val: "loaded"}
+	}), ExpirationOption(time.Hour)))
+	assert.Nil(t, err)
+
+	// the expired element is replaced, the unexpired one is not loaded
+	assert.Equal(t, WarmProgress{Loaded: 1, Skipped: 1}, result.WarmProgress)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	v, _ := cache.Get("0")
+	assert.Equal(t, "loaded", v.Val)
+	v, _ = cache.Get("1")
+	assert.Equal(t, "fresh", v.Val)
+	assert.Equal(t, 2, cache.Size())
+}
+
+func TestLRUCache_WarmCancel(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cache := NewLRUCache(WithClock(clock))
+
+	var calls int32
+	loader := WithLoader(func(key string) *Value {
+		atomic.AddInt32(&calls, 1)
+		return &Value{Val: key}
+	})
+
+	_, err := cache.Warm(context.Background(), warmKeys(1))
+	assert.Equal(t, ErrNoLoader, err)
+
+	// rate limited by ticks of the clock
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	var result WarmResult
+	go func() {
+		result, err = cache.Warm(ctx, warmKeys(10), WarmEntryOpts(loader), WarmRate(1))
+		close(done)
+	}()
+
+	// pending timers are the clean ticker and the rate ticker, each tick is received by a worker
+	clock.BlockUntil(2)
+	clock.Advance(2 * time.Second)
+	cancel()
+	<-done
+
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 2, result.Loaded)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
@@ -1,9 +1,15 @@
 module goober
 
-go 1.15
+go 1.21
 
 require (
 	github.com/cespare/xxhash/v2 v2.1.1
 	github.com/emirpasic/gods v1.12.0
+	github.com/stretchr/testify v1.3.0
 	go.uber.org/atomic v1.8.0
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+)
@@ -0,0 +1,304 @@
+// Package lincheck checks histories of concurrent operations for linearizability
+// against a sequential specification.
+//
+// Operations are recorded with a Recorder while clients run them concurrently, then
+// Check searches for a sequential order of the operations which respects their real-time
+// order and is accepted by the Model, with the algorithm of Wing & Gong improved by Lowe.
+package lincheck
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Operation a completed operation of a history, Call and Return are logical timestamps
+type Operation struct {
+	ClientID int
+	Input    interface{}
+	Output   interface{}
+	Call     int64
+	Return   int64
+}
+
+// Model a sequential specification of a concurrent object
+type Model struct {
+	// Init returns the initial state
+	Init func() interface{}
+
+	// Step returns whether output is a valid output of input in state, and the next state.
+	// It must not modify state.
+	Step func(state, input, output interface{}) (bool, interface{})
+
+	// Equal reports whether two states are equal, == if nil
+	Equal func(a, b interface{}) bool
+
+	// Partition splits a history into independent histories, e.g. by key of a map, optional
+	Partition func(history []Operation) [][]Operation
+
+	// Describe describes an operation in reports, optional
+	Describe func(input, output interface{}) string
+}
+
+// Result result of Check
+type Result struct {
+	// Ok is true if the history is linearizable
+	Ok bool
+
+	// Violation is a minimal non-linearizable sub-history of a partition if not Ok,
+	// removing any operation of it makes it linearizable
+	Violation []Operation
+}
+
+// Recorder records a history of concurrent operations, it is safe for concurrent use
+type Recorder struct {
+	clock int64
+
+	mu  sync.Mutex
+	ops []Operation
+}
+
+// Record calls f as an operation of client with input, recording the output of f
+func (r *Recorder) Record(clientID int, input interface{}, f func() interface{}) {
+	call := atomic.AddInt64(&r.clock, 1)
+	output := f()
+	ret := atomic.AddInt64(&r.clock, 1)
+
+	r.mu.Lock()
+	r.ops = append(r.ops, Operation{ClientID: clientID, Input: input, Output: output, Call: call, Return: ret})
+	r.mu.Unlock()
+}
+
+// History returns the recorded operations
+func (r *Recorder) History() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Operation(nil), r.ops...)
+}
+
+// Run runs clients goroutines calling op ops times each with a shared Recorder,
+// returning the recorded history
+func Run(clients, ops int, op func(r *Recorder, clientID, i int)) []Operation {
+	r := &Recorder{}
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for i := 0; i < ops; i++ {
+				op(r, c, i)
+			}
+		}(c)
+	}
+	wg.Wait()
+	return r.History()
+}
+
+// Check checks whether history is linearizable with model, returning a minimal
+// violating history if it is not
+func Check(model Model, history []Operation) Result {
+	partitions := [][]Operation{history}
+	if model.Partition != nil {
+		partitions = model.Partition(history)
+	}
+
+	for _, p := range partitions {
+		if !checkPartition(model, p) {
+			return Result{Ok: false, Violation: minimize(model, p)}
+		}
+	}
+	return Result{Ok: true}
+}
+
+// minimize removes operations from a non-linearizable history while it stays non-linearizable
+func minimize(model Model, history []Operation) []Operation {
+	ops := append([]Operation(nil), history...)
+	for i := 0; i < len(ops); {
+		candidate := make([]Operation, 0, len(ops)-1)
+		candidate = append(candidate, ops[:i]...)
+		candidate = append(candidate, ops[i+1:]...)
+		if !checkPartition(model, candidate) {
+			ops = candidate
+			continue
+		}
+		i++
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Call < ops[j].Call })
+	return ops
+}
+
+// Format formats a history in call order with the Describe of model
+func Format(model Model, history []Operation) string {
+	ops := append([]Operation(nil), history...)
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Call < ops[j].Call })
+
+	var b strings.Builder
+	for _, op := range ops {
+		desc := fmt.Sprintf("%v -> %v", op.Input, op.Output)
+		if model.Describe != nil {
+			desc = model.Describe(op.Input, op.Output)
+		}
+		fmt.Fprintf(&b, "client %d [%d, %d] %s\n", op.ClientID, op.Call, op.Return, desc)
+	}
+	return b.String()
+}
+
+// event a call or return of an operation in the doubly linked list of the search
+type event struct {
+	id     int
+	call   bool
+	input  interface{}
+	output interface{}
+	// match the return event of a call event
+	match      *event
+	prev, next *event
+}
+
+// lift removes the call event and its return event from the list
+func (e *event) lift() {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	r := e.match
+	r.prev.next = r.next
+	if r.next != nil {
+		r.next.prev = r.prev
+	}
+}
+
+// unlift puts back the call event and its return event lifted by lift
+func (e *event) unlift() {
+	r := e.match
+	r.prev.next = r
+	if r.next != nil {
+		r.next.prev = r
+	}
+	e.prev.next = e
+	e.next.prev = e
+}
+
+// bitset of linearized operations
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) set(i int) bitset {
+	b[i/64] |= 1 << uint(i%64)
+	return b
+}
+
+func (b bitset) clear(i int) bitset {
+	b[i/64] &^= 1 << uint(i%64)
+	return b
+}
+
+func (b bitset) clone() bitset {
+	return append(bitset(nil), b...)
+}
+
+func (b bitset) key() string {
+	var s strings.Builder
+	for _, w := range b {
+		fmt.Fprintf(&s, "%016x", w)
+	}
+	return s.String()
+}
+
+type frame struct {
+	e     *event
+	state interface{}
+}
+
+// checkPartition searches a linearization of history depth first: the first pending call
+// is linearized if the model accepts it and the resulting configuration was not seen,
+// otherwise the next pending call is tried; a return event reached means the last
+// linearized call must be undone.
+func checkPartition(model Model, history []Operation) bool {
+	equal := model.Equal
+	if equal == nil {
+		equal = func(a, b interface{}) bool { return a == b }
+	}
+
+	head := buildEvents(history)
+	state := model.Init()
+	linearized := newBitset(len(history))
+	seen := make(map[string][]interface{})
+	var stack []frame
+
+	e := head.next
+	for head.next != nil {
+		if e.call {
+			ok, next := model.Step(state, e.input, e.match.output)
+			if ok {
+				candidate := linearized.clone().set(e.id)
+				key := candidate.key()
+				if !containsState(seen[key], next, equal) {
+					seen[key] = append(seen[key], next)
+					stack = append(stack, frame{e: e, state: state})
+					state = next
+					linearized = candidate
+					e.lift()
+					e = head.next
+					continue
+				}
+			}
+			e = e.next
+			continue
+		}
+
+		// a return event, no pending call can be linearized before it
+		if len(stack) == 0 {
+			return false
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		state = top.state
+		linearized.clear(top.e.id)
+		top.e.unlift()
+		e = top.e.next
+	}
+	return true
+}
+
+func containsState(states []interface{}, state interface{}, equal func(a, b interface{}) bool) bool {
+	for _, s := range states {
+		if equal(s, state) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildEvents returns the sentinel head of the events of history in time order
+func buildEvents(history []Operation) *event {
+	type timed struct {
+		time int64
+		e    *event
+	}
+	events := make([]timed, 0, 2*len(history))
+	for i, op := range history {
+		ret := &event{id: i, output: op.Output}
+		call := &event{id: i, call: true, input: op.Input, match: ret}
+		events = append(events, timed{op.Call, call}, timed{op.Return, ret})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].time != events[j].time {
+			return events[i].time < events[j].time
+		}
+		// calls first, operations with equal timestamps overlap
+		return events[i].e.call && !events[j].e.call
+	})
+
+	head := &event{id: -1}
+	prev := head
+	for _, t := range events {
+		t.e.prev = prev
+		prev.next = t.e
+		prev = t.e
+	}
+	return head
+}
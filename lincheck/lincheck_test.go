@@ -0,0 +1,158 @@
+package lincheck
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	errFull  = errors.New("full")
+	errEmpty = errors.New("empty")
+)
+
+func op(client int, input, output interface{}, call, ret int64) Operation {
+	return Operation{ClientID: client, Input: input, Output: output, Call: call, Return: ret}
+}
+
+func get(key string) MapInput {
+	return MapInput{Op: MapGet, Key: key}
+}
+
+func put(key string, value interface{}) MapInput {
+	return MapInput{Op: MapPut, Key: key, Value: value}
+}
+
+func found(value interface{}) MapOutput {
+	return MapOutput{Value: value, Ok: true}
+}
+
+func TestCheck_Sequential(t *testing.T) {
+	history := []Operation{
+		op(0, get("a"), MapOutput{}, 1, 2),
+		op(0, put("a", 1), nil, 3, 4),
+		op(0, get("a"), found(1), 5, 6),
+		op(0, MapInput{Op: MapDelete, Key: "a"}, nil, 7, 8),
+		op(0, get("a"), MapOutput{}, 9, 10),
+	}
+	assert.True(t, Check(MapModel(), history).Ok)
+
+	// a stale read after the delete returned
+	history[4].Output = found(1)
+	assert.False(t, Check(MapModel(), history).Ok)
+}
+
+func TestCheck_Concurrent(t *testing.T) {
+	// the put overlaps both reads, the first read may see it and the second must
+	history := []Operation{
+		op(0, put("a", 1), nil, 1, 10),
+		op(1, get("a"), found(1), 2, 3),
+		op(2, get("a"), found(1), 4, 5),
+	}
+	assert.True(t, Check(MapModel(), history).Ok)
+
+	// a read cannot see the put and then a later read miss it
+	history[2].Output = MapOutput{}
+	assert.False(t, Check(MapModel(), history).Ok)
+
+	// unless the reads overlap
+	history[2].Call = 2
+	assert.True(t, Check(MapModel(), history).Ok)
+}
+
+func TestCheck_MinimalViolation(t *testing.T) {
+	history := []Operation{
+		op(0, put("b", 1), nil, 1, 2),
+		op(1, get("a"), MapOutput{}, 3, 4),
+		op(0, put("a", 1), nil, 5, 6),
+		op(1, get("b"), found(1), 7, 8),
+		op(2, get("a"), MapOutput{}, 9, 10),
+		op(0, put("a", 2), nil, 11, 12),
+		op(1, get("a"), found(2), 13, 14),
+	}
+	result := Check(MapModel(), history)
+	assert.False(t, result.Ok)
+	// only the partition of a, without the operations not needed by the violation
+	assert.Equal(t, []Operation{history[2], history[4]}, result.Violation)
+	assert.Equal(t, "client 0 [5, 6] Put(a, 1)\nclient 2 [9, 10] Get(a) -> (<nil>, false)\n",
+		Format(MapModel(), result.Violation))
+}
+
+func TestCheck_Queue(t *testing.T) {
+	model := QueueModel(1, errFull, errEmpty)
+	putIn := func(v interface{}) QueueInput { return QueueInput{Op: QueuePut, Value: v} }
+	getIn := QueueInput{Op: QueueGet}
+
+	history := []Operation{
+		op(0, putIn(1), QueueOutput{}, 1, 4),
+		op(1, putIn(2), QueueOutput{Err: errFull}, 2, 3),
+		op(1, getIn, QueueOutput{Value: 1}, 5, 6),
+		op(1, getIn, QueueOutput{Err: errEmpty}, 7, 8),
+	}
+	// the failed put must be linearized after the put of 1, which overlaps it
+	assert.True(t, Check(model, history).Ok)
+
+	history[1].Call, history[1].Return = -1, 0
+	assert.False(t, Check(model, history).Ok)
+
+	// FIFO order of sequential puts
+	model = QueueModel(2, errFull, errEmpty)
+	history = []Operation{
+		op(0, putIn(1), QueueOutput{}, 1, 2),
+		op(0, putIn(2), QueueOutput{}, 3, 4),
+		op(1, getIn, QueueOutput{Value: 2}, 5, 6),
+	}
+	assert.False(t, Check(model, history).Ok)
+}
+
+// lockedQueue a trivially linearizable queue checking the harness end to end
+type lockedQueue struct {
+	mu    sync.Mutex
+	items []interface{}
+	cap   int
+}
+
+func (q *lockedQueue) put(v interface{}) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) >= q.cap {
+		return errFull
+	}
+	q.items = append(q.items, v)
+	return nil
+}
+
+func (q *lockedQueue) get() (interface{}, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 {
+		return nil, errEmpty
+	}
+	v := q.items[0]
+	q.items = q.items[1:]
+	return v, nil
+}
+
+func TestRun(t *testing.T) {
+	q := &lockedQueue{cap: 3}
+	history := Run(4, 50, func(r *Recorder, client, i int) {
+		if i%2 == 0 {
+			v := client*1000 + i
+			r.Record(client, QueueInput{Op: QueuePut, Value: v}, func() interface{} {
+				return QueueOutput{Err: q.put(v)}
+			})
+			return
+		}
+		r.Record(client, QueueInput{Op: QueueGet}, func() interface{} {
+			v, err := q.get()
+			return QueueOutput{Value: v, Err: err}
+		})
+	})
+	assert.Len(t, history, 200)
+
+	model := QueueModel(3, errFull, errEmpty)
+	result := Check(model, history)
+	assert.True(t, result.Ok, Format(model, result.Violation))
+}
@@ -0,0 +1,208 @@
+package lincheck
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MapOp an operation of MapModel
+type MapOp int
+
+const (
+	MapGet MapOp = iota
+	MapPut
+	MapDelete
+	// MapSwap outputs the previous value like Get
+	MapSwap
+	// MapLoadAndDelete outputs the previous value like Get
+	MapLoadAndDelete
+	// MapCompareAndSwap outputs whether it swapped
+	MapCompareAndSwap
+	// MapCompareAndDelete outputs whether it deleted
+	MapCompareAndDelete
+)
+
+func (op MapOp) String() string {
+	switch op {
+	case MapGet:
+		return "Get"
+	case MapPut:
+		return "Put"
+	case MapDelete:
+		return "Delete"
+	case MapSwap:
+		return "Swap"
+	case MapLoadAndDelete:
+		return "LoadAndDelete"
+	case MapCompareAndSwap:
+		return "CompareAndSwap"
+	case MapCompareAndDelete:
+		return "CompareAndDelete"
+	}
+	return fmt.Sprintf("MapOp(%d)", int(op))
+}
+
+// MapInput input of an operation of MapModel, Value is the value put or swapped,
+// Old the value compared by MapCompareAndSwap and MapCompareAndDelete
+type MapInput struct {
+	Op    MapOp
+	Key   string
+	Value interface{}
+	Old   interface{}
+}
+
+// MapOutput output of Get, Swap and LoadAndDelete of MapModel, Put and Delete output nothing
+type MapOutput struct {
+	Value interface{}
+	Ok    bool
+}
+
+// mapState the state of a single key, values must be comparable
+type mapState struct {
+	value interface{}
+	ok    bool
+}
+
+// matches reports whether output is the MapOutput of reading s
+func (s mapState) matches(output interface{}) bool {
+	out := output.(MapOutput)
+	return out.Ok == s.ok && (!s.ok || out.Value == s.value)
+}
+
+// MapModel the specification of a map with the operations of MapOp, partitioned by key
+func MapModel() Model {
+	return Model{
+		Init: func() interface{} { return mapState{} },
+		Step: func(state, input, output interface{}) (bool, interface{}) {
+			s := state.(mapState)
+			in := input.(MapInput)
+			switch in.Op {
+			case MapGet:
+				return s.matches(output), s
+			case MapPut:
+				return true, mapState{value: in.Value, ok: true}
+			case MapDelete:
+				return true, mapState{}
+			case MapSwap:
+				return s.matches(output), mapState{value: in.Value, ok: true}
+			case MapLoadAndDelete:
+				return s.matches(output), mapState{}
+			case MapCompareAndSwap:
+				if s.ok && s.value == in.Old {
+					return output == true, mapState{value: in.Value, ok: true}
+				}
+				return output == false, s
+			case MapCompareAndDelete:
+				if s.ok && s.value == in.Old {
+					return output == true, mapState{}
+				}
+				return output == false, s
+			}
+			return false, s
+		},
+		Partition: func(history []Operation) [][]Operation {
+			index := make(map[string]int)
+			var partitions [][]Operation
+			for _, op := range history {
+				key := op.Input.(MapInput).Key
+				i, ok := index[key]
+				if !ok {
+					i = len(partitions)
+					index[key] = i
+					partitions = append(partitions, nil)
+				}
+				partitions[i] = append(partitions[i], op)
+			}
+			return partitions
+		},
+		Describe: func(input, output interface{}) string {
+			in := input.(MapInput)
+			switch in.Op {
+			case MapGet, MapLoadAndDelete:
+				out := output.(MapOutput)
+				return fmt.Sprintf("%s(%s) -> (%v, %v)", in.Op, in.Key, out.Value, out.Ok)
+			case MapPut:
+				return fmt.Sprintf("Put(%s, %v)", in.Key, in.Value)
+			case MapSwap:
+				out := output.(MapOutput)
+				return fmt.Sprintf("Swap(%s, %v) -> (%v, %v)", in.Key, in.Value, out.Value, out.Ok)
+			case MapCompareAndSwap:
+				return fmt.Sprintf("CompareAndSwap(%s, %v, %v) -> %v", in.Key, in.Old, in.Value, output)
+			case MapCompareAndDelete:
+				return fmt.Sprintf("CompareAndDelete(%s, %v) -> %v", in.Key, in.Old, output)
+			}
+			return fmt.Sprintf("%s(%s)", in.Op, in.Key)
+		},
+	}
+}
+
+// QueueOp an operation of QueueModel
+type QueueOp int
+
+const (
+	QueuePut QueueOp = iota
+	QueueGet
+)
+
+// QueueInput input of an operation of QueueModel, Value is the value put
+type QueueInput struct {
+	Op    QueueOp
+	Value interface{}
+}
+
+// QueueOutput output of an operation of QueueModel, Value is the value got
+type QueueOutput struct {
+	Value interface{}
+	Err   error
+}
+
+// QueueModel the specification of a bounded FIFO queue holding at most capacity items,
+// Put fails with errFull when it is full and Get fails with errEmpty when it is empty
+func QueueModel(capacity int, errFull, errEmpty error) Model {
+	return Model{
+		Init: func() interface{} { return []interface{}(nil) },
+		Step: func(state, input, output interface{}) (bool, interface{}) {
+			items := state.([]interface{})
+			in := input.(QueueInput)
+			out := output.(QueueOutput)
+			switch in.Op {
+			case QueuePut:
+				if len(items) >= capacity {
+					return errors.Is(out.Err, errFull), items
+				}
+				if out.Err != nil {
+					return false, items
+				}
+				next := make([]interface{}, len(items), len(items)+1)
+				copy(next, items)
+				return true, append(next, in.Value)
+			case QueueGet:
+				if len(items) == 0 {
+					return errors.Is(out.Err, errEmpty), items
+				}
+				return out.Err == nil && out.Value == items[0], items[1:]
+			}
+			return false, items
+		},
+		Equal: func(a, b interface{}) bool {
+			x, y := a.([]interface{}), b.([]interface{})
+			if len(x) != len(y) {
+				return false
+			}
+			for i := range x {
+				if x[i] != y[i] {
+					return false
+				}
+			}
+			return true
+		},
+		Describe: func(input, output interface{}) string {
+			in := input.(QueueInput)
+			out := output.(QueueOutput)
+			if in.Op == QueuePut {
+				return fmt.Sprintf("Put(%v) -> %v", in.Value, out.Err)
+			}
+			return fmt.Sprintf("Get() -> (%v, %v)", out.Value, out.Err)
+		},
+	}
+}
@@ -6,52 +6,108 @@ import (
 	"sync/atomic"
 )
 
+// LockFreeQueue a concurrency safe ring, like Ring it holds size-1 items.
+//
+// head and tail only grow, an item is put at tail%(size-1) and got at head%(size-1).
+// Put and Get take their position with a cas of tail or head, then wait for the sequence
+// of the slot, so Get never reads a slot before the item is written and Put never overwrites
+// an item not read yet.
+//
+// Despite its name the queue is not lock-free: positions are taken without locks, but a Put or
+// Get spins until the goroutine that took the previous turn of its slot finishes. If that
+// goroutine is descheduled between its cas and its write or read of the slot, the waiting
+// goroutine is blocked until it runs again.
 type LockFreeQueue struct {
 	Ring
+
+	// seqs sequence of each slot, pos when the item at pos can be put,
+	// pos+1 when the item put at pos can be got
+	seqs []uint64
 }
 
 func NewLockFreeQueue(size uint64) *LockFreeQueue {
+	capacity := uint64(0)
+	if size > 0 {
+		capacity = size - 1
+	}
+	seqs := make([]uint64, capacity)
+	for i := range seqs {
+		seqs[i] = uint64(i)
+	}
 	return &LockFreeQueue{
-		Ring{
+		Ring: Ring{
 			size:  size,
-			items: make([]interface{}, size, size),
+			items: make([]interface{}, capacity, capacity),
 			head:  0,
 			tail:  0,
 		},
+		seqs: seqs,
 	}
 }
 
+// Put puts item at tail, it returns list.ErrFull if the queue is full.
+// It may block until the Get of the slot's previous item has read it.
 func (lfQueue *LockFreeQueue) Put(item interface{}) error {
-	tailPointer := &lfQueue.tail
+	capacity := uint64(len(lfQueue.items))
 	for {
-		// cas tail
-		oldValue := lfQueue.tail
-		newValue := (oldValue + 1) % lfQueue.size
-		if lfQueue.head == newValue {
-			return list.ErrFull
+		tail := atomic.LoadUint64(&lfQueue.tail)
+		head := atomic.LoadUint64(&lfQueue.head)
+		if tail-head >= capacity {
+			// full only if tail did not move while head was read
+			if atomic.LoadUint64(&lfQueue.tail) == tail {
+				return list.ErrFull
+			}
+			continue
 		}
 
-		if atomic.CompareAndSwapUint64(tailPointer, oldValue, newValue) {
-			lfQueue.items[oldValue] = item
+		// cas tail
+		if atomic.CompareAndSwapUint64(&lfQueue.tail, tail, tail+1) {
+			i := tail % capacity
+			// wait for the item put at tail-capacity to be read
+			for atomic.LoadUint64(&lfQueue.seqs[i]) != tail {
+				runtime.Gosched()
+			}
+			lfQueue.items[i] = item
+			atomic.StoreUint64(&lfQueue.seqs[i], tail+1)
 			return nil
 		}
 		runtime.Gosched()
 	}
 }
 
+// Get gets the item at head, it returns list.ErrEmpty if the queue is empty.
+// It may block until the Put of the item has written it.
 func (lfQueue *LockFreeQueue) Get() (interface{}, error) {
-	headPointer := &lfQueue.head
+	capacity := uint64(len(lfQueue.items))
 	for {
-		// cas head
-		oldValue := lfQueue.head
-		if lfQueue.tail == oldValue {
-			return nil, list.ErrEmpty
+		head := atomic.LoadUint64(&lfQueue.head)
+		tail := atomic.LoadUint64(&lfQueue.tail)
+		if head == tail {
+			// empty only if head did not move while tail was read
+			if atomic.LoadUint64(&lfQueue.head) == head {
+				return nil, list.ErrEmpty
+			}
+			continue
 		}
 
-		newValue := (oldValue + 1) % lfQueue.size
-		if atomic.CompareAndSwapUint64(headPointer, oldValue, newValue) {
-			return lfQueue.items[oldValue], nil
+		// cas head
+		if atomic.CompareAndSwapUint64(&lfQueue.head, head, head+1) {
+			i := head % capacity
+			// wait for the item put at head to be written
+			for atomic.LoadUint64(&lfQueue.seqs[i]) != head+1 {
+				runtime.Gosched()
+			}
+			item := lfQueue.items[i]
+			lfQueue.items[i] = nil
+			atomic.StoreUint64(&lfQueue.seqs[i], head+capacity)
+			return item, nil
 		}
 		runtime.Gosched()
 	}
 }
+
+// Length number of items in the queue
+func (lfQueue *LockFreeQueue) Length() uint64 {
+	head := atomic.LoadUint64(&lfQueue.head)
+	return atomic.LoadUint64(&lfQueue.tail) - head
+}
@@ -0,0 +1,34 @@
+package ring
+
+import (
+	"testing"
+
+	"goober/lincheck"
+	"goober/list"
+)
+
+func TestLockFreeQueue_Linearizable(t *testing.T) {
+	const size = 4
+	// one slot of the ring is kept empty to tell full from empty
+	model := lincheck.QueueModel(size-1, list.ErrFull, list.ErrEmpty)
+	for round := 0; round < 50; round++ {
+		q := NewLockFreeQueue(size)
+		history := lincheck.Run(4, 200, func(r *lincheck.Recorder, client, i int) {
+			if (client+i)%2 == 0 {
+				value := client*100 + i
+				r.Record(client, lincheck.QueueInput{Op: lincheck.QueuePut, Value: value}, func() interface{} {
+					return lincheck.QueueOutput{Err: q.Put(value)}
+				})
+				return
+			}
+			r.Record(client, lincheck.QueueInput{Op: lincheck.QueueGet}, func() interface{} {
+				v, err := q.Get()
+				return lincheck.QueueOutput{Value: v, Err: err}
+			})
+		})
+
+		if result := lincheck.Check(model, history); !result.Ok {
+			t.Fatalf("round %d not linearizable:\n%s", round, lincheck.Format(model, result.Violation))
+		}
+	}
+}